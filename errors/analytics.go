@@ -0,0 +1,33 @@
+package errors
+
+// AnalyticsRow flattens err into typed columns for ingestion into columnar
+// stores, with a stable schema distinct from MarshalJSON:
+//
+//	"message":      string, the messages of the chain (see GetMessage)
+//	"code":         string, see GetCode
+//	"category":     string, see RoutingCategory
+//	"top_function": string, top frame of the original stack, or ""
+//	"top_file":     string
+//	"top_line":     int, 0 if there is no stack
+//	"depth":        int, the number of errors in the chain
+//
+// Returns nil for a nil error.
+func AnalyticsRow(err error) map[string]interface{} {
+	if err == nil {
+		return nil
+	}
+
+	var top StackFrame
+	if frames := originalFrames(err); len(frames) > 0 {
+		top = frames[0]
+	}
+	return map[string]interface{}{
+		"message":      GetMessage(err),
+		"code":         GetCode(err),
+		"category":     RoutingCategory(err),
+		"top_function": top.Function,
+		"top_file":     top.File,
+		"top_line":     top.Line,
+		"depth":        len(walk(err)),
+	}
+}
@@ -0,0 +1,44 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAnalyticsRow(t *testing.T) {
+	inner := NewWithCode("RATE_LIMITED", "too many requests")
+	err := WrapCategory(fmt.Errorf("retry: %w", inner), "billing", "charge failed")
+
+	row := AnalyticsRow(err)
+	if len(row) != 7 {
+		t.Errorf("unexpected columns %v", row)
+	}
+	for column, expected := range map[string]interface{}{
+		"message":  "charge failed retry too many requests",
+		"code":     "RATE_LIMITED",
+		"category": "billing",
+		"depth":    3,
+	} {
+		if row[column] != expected {
+			t.Errorf("unexpected %s %#v, expected %#v", column, row[column], expected)
+		}
+	}
+	if f, ok := row["top_function"].(string); !ok || !strings.HasSuffix(f, "errors.TestAnalyticsRow") {
+		t.Errorf("unexpected top_function %#v", row["top_function"])
+	}
+	if f, ok := row["top_file"].(string); !ok || !strings.HasSuffix(f, "analytics_test.go") {
+		t.Errorf("unexpected top_file %#v", row["top_file"])
+	}
+	if line, ok := row["top_line"].(int); !ok || line <= 0 {
+		t.Errorf("unexpected top_line %#v", row["top_line"])
+	}
+
+	row = AnalyticsRow(fmt.Errorf("plain"))
+	if row["message"] != "plain" || row["top_function"] != "" || row["top_line"] != 0 || row["depth"] != 1 {
+		t.Errorf("unexpected row for a plain error %v", row)
+	}
+	if AnalyticsRow(nil) != nil {
+		t.Error("expected no row for nil")
+	}
+}
@@ -0,0 +1,86 @@
+package errors
+
+import (
+	"sync"
+)
+
+var (
+	categoryChannelsMutex sync.RWMutex
+	categoryChannels      = make(map[string]string)
+)
+
+// WrapCategory is the same as Wrap, but also assigns a routing category to
+// the new error (e.g. "billing"), which determines where alerts for the
+// error are sent (see AlertChannel).  Categories are distinct from state.
+func WrapCategory(err error, category, msg string) DropboxError {
+	return enrich(&DropboxBaseError{
+		Msg:      sanitizeMessage(msg),
+		inner:    err,
+		category: category,
+		pcs:      callers(),
+	})
+}
+
+// This returns the routing category assigned by WrapCategory, if any.
+func (e *DropboxBaseError) GetCategory() string {
+	if e == nil {
+		return ""
+	}
+	return e.category
+}
+
+// RoutingCategory returns the first (i.e. outermost) routing category found
+// in err's chain, or "" if there is none.
+func RoutingCategory(err error) string {
+	for _, e := range walk(err) {
+		if c, ok := e.(interface{ GetCategory() string }); ok {
+			if category := c.GetCategory(); category != "" {
+				return category
+			}
+		}
+	}
+	return ""
+}
+
+// RegisterCategoryChannel maps a routing category to the alert channel (e.g.
+// a Slack channel) which should receive its errors.
+func RegisterCategoryChannel(category, channel string) {
+	categoryChannelsMutex.Lock()
+	defer categoryChannelsMutex.Unlock()
+	categoryChannels[category] = channel
+}
+
+// AlertChannel returns the alert channel registered for err's routing
+// category, or "" if there is none.
+func AlertChannel(err error) string {
+	categoryChannelsMutex.RLock()
+	defer categoryChannelsMutex.RUnlock()
+	return categoryChannels[RoutingCategory(err)]
+}
+
+// MarkExpected marks err as expected, i.e. business as usual (e.g. a
+// validation failure) rather than a bug, so that it can be kept out of
+// paging alerts.  err is wrapped without adding a message, so that it stays
+// in the chain (e.g. for ContainsError and the standard library's errors.Is).
+// Errors are unexpected unless marked.
+func MarkExpected(err error) DropboxError {
+	if err == nil {
+		return nil
+	}
+	return enrich(&DropboxBaseError{
+		inner:    err,
+		expected: true,
+		pcs:      callers(),
+	})
+}
+
+// IsExpected returns whether any error in err's chain has been marked with
+// MarkExpected.
+func IsExpected(err error) bool {
+	for _, e := range walk(err) {
+		if derr, ok := e.(*DropboxBaseError); ok && derr.expected {
+			return true
+		}
+	}
+	return false
+}
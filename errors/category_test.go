@@ -0,0 +1,72 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCategories(t *testing.T) {
+	defer func() { categoryChannels = make(map[string]string) }()
+
+	leaf := fmt.Errorf("card declined")
+	inner := WrapCategory(leaf, "payments", "charge failed")
+	outer := WrapCategory(Wrap(inner, "checkout failed"), "billing", "invoice failed")
+
+	if c := inner.(*DropboxBaseError).GetCategory(); c != "payments" {
+		t.Errorf("unexpected category %q", c)
+	}
+	if strings.Index(inner.GetStack(), "TestCategories") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", inner.GetStack())
+	}
+	if c := RoutingCategory(outer); c != "billing" {
+		t.Errorf("expected the outermost category, got %q", c)
+	}
+	if c := RoutingCategory(Wrap(inner, "checkout failed")); c != "payments" {
+		t.Errorf("expected the inner category, got %q", c)
+	}
+	if c := RoutingCategory(leaf); c != "" {
+		t.Errorf("expected no category, got %q", c)
+	}
+
+	RegisterCategoryChannel("payments", "#payments-alerts")
+	if ch := AlertChannel(inner); ch != "#payments-alerts" {
+		t.Errorf("unexpected alert channel %q", ch)
+	}
+	if ch := AlertChannel(outer); ch != "" {
+		t.Errorf("expected no alert channel for an unregistered category, got %q", ch)
+	}
+}
+
+func TestExpected(t *testing.T) {
+	validation := New("invalid email")
+	if IsExpected(validation) || IsExpected(fmt.Errorf("plain")) || IsExpected(nil) {
+		t.Error("errors should be unexpected unless marked")
+	}
+
+	marked := MarkExpected(validation)
+	if !IsExpected(marked) {
+		t.Error("expected a marked error to be expected")
+	}
+	if IsExpected(validation) {
+		t.Error("marking should not modify the original error")
+	}
+	if GetMessage(marked) != "invalid email" {
+		t.Errorf("marking should not add a message, got %q", GetMessage(marked))
+	}
+	if !stderrors.Is(marked, validation) || !ContainsError(marked, validation) {
+		t.Error("the marked error should stay in the chain")
+	}
+	if !IsExpected(Wrap(marked, "signup failed")) {
+		t.Error("expected the mark to be found through wrappers")
+	}
+
+	plain := MarkExpected(fmt.Errorf("not found"))
+	if !IsExpected(plain) || GetMessage(plain) != "not found" {
+		t.Errorf("unexpected marked plain error %q", GetMessage(plain))
+	}
+	if MarkExpected(nil) != nil {
+		t.Error("expected nil for a nil error")
+	}
+}
@@ -0,0 +1,389 @@
+package errors
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Returns the errors directly wrapped by err: the inner error of a
+// DropboxError, or the error(s) returned by the standard library's
+// Unwrap() error / Unwrap() []error methods (e.g. for errors created by
+// fmt.Errorf's %w verb, or errors.Join).
+func unwrap(err error) []error {
+	var out []error
+	switch e := err.(type) {
+	// Checked first, for DropboxErrors wrapping several errors (MultiError).
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			if inner != nil {
+				out = append(out, inner)
+			}
+		}
+	case DropboxError:
+		if inner := e.GetInner(); inner != nil {
+			out = append(out, inner)
+		}
+	case interface{ Unwrap() error }:
+		if inner := e.Unwrap(); inner != nil {
+			out = append(out, inner)
+		}
+	}
+	return out
+}
+
+// Maximum depth of the errors returned by walk (err itself being at depth 0),
+// as a guard against pathological chains.  Errors wrapping several others
+// (e.g. MultiError) don't count against it beyond their own level.
+const maxChainDepth = 100
+
+// Returns all errors in err's chain, outermost first, including err itself.
+// Errors wrapping several others (see unwrap) are traversed depth first.
+// Each error is returned at most once, so that traversal terminates even if
+// the chain has a cycle.
+func walk(err error) []error {
+	var out []error
+	visited := make(map[error]bool)
+	var visit func(err error, depth int)
+	visit = func(err error, depth int) {
+		if err == nil || depth >= maxChainDepth {
+			return
+		}
+		// Non-comparable errors can't be tracked, but can't form cycles
+		// on their own either.
+		if isComparable(err) {
+			if visited[err] {
+				return
+			}
+			visited[err] = true
+		}
+		out = append(out, err)
+		for _, inner := range unwrap(err) {
+			visit(inner, depth+1)
+		}
+	}
+	visit(err, 0)
+	return out
+}
+
+// Returns the message err contributes to its chain.  For errors which aren't
+// DropboxErrors, this is err.Error() without the text of the errors it wraps
+// (which usually embeds them, e.g. fmt.Errorf("query failed: %w", err)).
+func linkMessage(err error) string {
+	if m, ok := err.(*MultiError); ok {
+		// The contained errors contribute their own messages.
+		return m.summary()
+	}
+	if dbe, ok := err.(DropboxError); ok {
+		return dbe.GetMessage()
+	}
+
+	msg := err.Error()
+	// Wrapped errors' text is usually appended (e.g. "...: %w"), so the last
+	// occurrence is stripped, starting with the last wrapped error.
+	inners := unwrap(err)
+	for i := len(inners) - 1; i >= 0; i-- {
+		// %w embeds DropboxBaseErrors by their message chain (see Format)
+		// rather than by Error().
+		if text := inners[i].Error(); strings.Contains(msg, text) {
+			msg = stripLast(msg, text)
+		} else {
+			msg = stripLast(msg, fmt.Sprint(inners[i]))
+		}
+	}
+	return strings.Trim(msg, " :\n")
+}
+
+// Removes the last occurrence of text from s.
+func stripLast(s, text string) string {
+	if i := strings.LastIndex(s, text); i >= 0 {
+		return s[:i] + s[i+len(text):]
+	}
+	return s
+}
+
+// Chain builds a single error chain out of errs, where each argument wraps
+// the arguments following it, i.e. Chain(a, b, c) reads as "a, caused by b,
+// caused by c".  Nil arguments are skipped, and nil is returned if no
+// argument is non-nil.
+//
+// Stacks are not re-captured: each link retains the message, stack, context
+// and state of the error it was built from.  Any inner error previously
+// wrapped by a non-final argument is replaced by the next argument, except
+// for MultiErrors, which keep their errors and hold the next argument after
+// them.  Non-final plain errors only contribute their own message (see
+// linkMessage), without the text of what they wrapped.  A lone plain error
+// is wrapped without adding a message.  The arguments themselves are not
+// modified.
+func Chain(errs ...error) DropboxError {
+	result := chain(errs)
+	if result == nil {
+		return nil
+	}
+	if dbe, ok := result.(DropboxError); ok {
+		return dbe
+	}
+	return &DropboxBaseError{inner: result}
+}
+
+// Same as Chain, but the last non-nil error is returned as-is when it is the
+// only one.
+func chain(errs []error) error {
+	var result error
+	for i := len(errs) - 1; i >= 0; i-- {
+		if errs[i] == nil {
+			continue
+		}
+		if result == nil {
+			result = errs[i]
+			continue
+		}
+		result = relink(errs[i], result)
+	}
+	return result
+}
+
+// Returns a new error carrying err's own information (without re-capturing
+// the stack) which wraps inner.  A MultiError is copied with its errors, and
+// inner (if any) added after them.  A plain error is replaced by its own
+// message (see linkMessage).
+func relink(err error, inner error) DropboxError {
+	switch e := err.(type) {
+	case *MultiError:
+		m := e.copy()
+		if inner != nil {
+			m.add(inner)
+		}
+		return m
+	case *DropboxBaseError:
+		c := e.copy()
+		c.inner = inner
+		return c
+	case DropboxError:
+		c := &DropboxBaseError{
+			Msg:     e.GetMessage(),
+			Stack:   e.GetStack(),
+			Context: e.GetContext(),
+			inner:   inner,
+		}
+		if state := e.GetState(); state != nil {
+			c.State = make(map[string]interface{}, len(state))
+			for key, value := range state {
+				c.State[key] = value
+			}
+		}
+		return c
+	default:
+		return &DropboxBaseError{
+			Msg:   linkMessage(err),
+			inner: inner,
+		}
+	}
+}
+
+// ReplaceInner swaps the error wrapped by e for newInner, keeping e's own
+// message, stack and state.  This is useful for translating a low-level
+// cause into a domain error without losing the context that was added on
+// top of it.  The replacement is refused (e is left unchanged) if it would
+// make e part of its own chain.
+func (e *DropboxBaseError) ReplaceInner(newInner error) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.setInner(newInner)
+	return e
+}
+
+// SetInnerChain replaces the error wrapped by e with the chain built out of
+// inners, i.e. inners[0] becomes e's inner, inners[1] its inner, and so on.
+// As with Chain, nil errors are skipped and every error but the last is
+// relinked without modifying it.  The replacement is refused (e is left
+// unchanged) if e is among inners or reachable from them.
+func (e *DropboxBaseError) SetInnerChain(inners []error) DropboxError {
+	if e == nil {
+		return nil
+	}
+	for _, inner := range inners {
+		if isComparable(inner) && inner == e {
+			return e
+		}
+	}
+	e.setInner(chain(inners))
+	return e
+}
+
+// All modifications of an existing error's inner error go through setInner,
+// which refuses (returning false) to make e reachable from its own inner
+// error.  Constructors such as Wrap don't need the check: a new error can't
+// be part of the chain it wraps.
+func (e *DropboxBaseError) setInner(inner error) bool {
+	if chainContains(inner, e) {
+		return false
+	}
+	e.inner = inner
+	return true
+}
+
+// Returns true if target is reachable from err by following inner errors.
+func chainContains(err error, target error) bool {
+	for _, e := range walk(err) {
+		if isComparable(e) && e == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if err can safely be compared with == and used as a map key.
+func isComparable(err error) bool {
+	return err == nil || reflect.TypeOf(err).Comparable()
+}
+
+// DropboxChain returns the elements of err's chain which implement
+// DropboxError, outermost first.  Plain errors are excluded.
+func DropboxChain(err error) []DropboxError {
+	var out []DropboxError
+	for _, e := range walk(err) {
+		if dbe, ok := e.(DropboxError); ok {
+			out = append(out, dbe)
+		}
+	}
+	return out
+}
+
+// Flatten returns every error of err's chain, outermost first, including
+// err itself and plain errors (see DropboxChain for DropboxErrors only), e.g.
+// to inspect the code or state of each level.  Errors wrapping several
+// others are traversed depth first, and each error is returned once, so
+// that cycles are harmless.  Returns nil for a nil error.
+func Flatten(err error) []error {
+	return walk(err)
+}
+
+// The message and code contributed by a single level of an error chain.
+type Link struct {
+	Msg  string
+	Code string
+}
+
+// Links returns the message and code of every level of err's chain, in the
+// order of Flatten.  Each level only contributes its own message and code,
+// e.g. the message of a plain error excludes the text of the errors it wraps
+// (see GetMessages), and a wrapper without a code has an empty Code even if
+// an inner error has one (see GetCode).  Returns nil for a nil error.
+func Links(err error) []Link {
+	var out []Link
+	for _, e := range walk(err) {
+		out = append(out, Link{Msg: linkMessage(e), Code: linkCode(e)})
+	}
+	return out
+}
+
+// RootCause returns the inner-most error of err's chain (e.g. the raw
+// *net.OpError), following GetInner and the standard library's Unwrap
+// methods.  For errors wrapping several others, the first one is followed.
+// Errors which wrap nothing are returned as-is, and nil is returned for nil.
+// Should the chain have a cycle, the last error before it repeats is
+// returned.
+func RootCause(err error) error {
+	visited := make(map[error]bool)
+	for i := 0; err != nil && i < maxChainDepth; i++ {
+		if isComparable(err) {
+			visited[err] = true
+		}
+		inners := unwrap(err)
+		if len(inners) == 0 || (isComparable(inners[0]) && visited[inners[0]]) {
+			break
+		}
+		err = inners[0]
+	}
+	return err
+}
+
+// Adopt returns a new error which carries context's message, stack and
+// state, with cause as its inner error.  This reconciles a descriptive error
+// created for an operation with the plain error which later turned out to
+// have caused it.  context itself is not modified.  Without a context, cause
+// is wrapped without adding a message (or nil is returned if it is nil too).
+func Adopt(context DropboxError, cause error) DropboxError {
+	if context == nil {
+		if cause == nil {
+			return nil
+		}
+		return &DropboxBaseError{inner: cause}
+	}
+
+	return relink(context, cause)
+}
+
+// InnerDepth returns the 0-based position of the first element of err's chain
+// which matches target, or -1 if there is none.  This tells how many layers
+// of wrapping sit above target.  Elements match as they would for the
+// standard library's errors.Is, i.e. if they are equal to target or their
+// Is(error) bool method reports so.
+func InnerDepth(err, target error) int {
+	for depth, e := range walk(err) {
+		if matches(e, target) {
+			return depth
+		}
+	}
+	return -1
+}
+
+// Returns true if err itself (ignoring the errors it wraps) matches target,
+// according to the standard library's errors.Is conventions.  The Is methods
+// of DropboxErrors are not consulted, since they cover the whole chain.
+func matches(err, target error) bool {
+	if isComparable(err) && err == target {
+		return true
+	}
+	if _, ok := err.(DropboxError); ok {
+		return false
+	}
+	if e, ok := err.(interface{ Is(error) bool }); ok {
+		return e.Is(target)
+	}
+	return false
+}
+
+// Validate checks the structure of err's chain, and returns an error
+// describing the first problem found: a cycle, or a typed-nil error (see
+// IsNil) in the chain, which would break traversal.  Returns nil for a
+// healthy chain.  This is meant as a sanity check for code which builds or
+// modifies chains.
+func Validate(err error) error {
+	// Only errors on the current path form a cycle; an error shared by
+	// sibling branches (e.g. under errors.Join) is fine, and is checked once.
+	onPath := make(map[error]bool)
+	checked := make(map[error]bool)
+	var check func(err error, depth int) error
+	check = func(err error, depth int) error {
+		if IsNil(err) {
+			return Newf("Nil error of type %T at depth %d of the chain", err, depth)
+		}
+		if isComparable(err) {
+			if onPath[err] {
+				return Newf("Cycle in the chain at depth %d: %q", depth, linkMessage(err))
+			}
+			if checked[err] {
+				return nil
+			}
+			onPath[err] = true
+			defer func() {
+				delete(onPath, err)
+				checked[err] = true
+			}()
+		}
+		for _, inner := range unwrap(err) {
+			if e := check(inner, depth+1); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+
+	if err == nil {
+		return nil
+	}
+	return check(err, 0)
+}
@@ -0,0 +1,401 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	first := New("parse failed")
+	second := Wrap(fmt.Errorf("replaced"), "fetch failed")
+	third := fmt.Errorf("connection refused")
+
+	chain := Chain(first, nil, second, third)
+	expected := "parse failed fetch failed connection refused"
+	if msg := GetMessage(chain); msg != expected {
+		t.Errorf("unexpected chain message %q, expected %q", msg, expected)
+	}
+
+	if chain.GetStack() != first.GetStack() {
+		t.Error("chain should retain the first error's stack")
+	}
+	if first.GetInner() != nil {
+		t.Error("Chain should not modify its arguments")
+	}
+
+	if Chain(nil, nil) != nil {
+		t.Error("chain of nils should be nil")
+	}
+
+	if single := Chain(nil, third); GetMessage(single) != "connection refused" ||
+		!stderrors.Is(single, third) {
+		t.Errorf("unexpected single error chain %q", GetMessage(single))
+	}
+
+	// Plain errors only contribute their own message.
+	wrapper := fmt.Errorf("dial failed: %w", fmt.Errorf("no route to host"))
+	if msg := GetMessage(Chain(wrapper, third)); msg != "dial failed connection refused" {
+		t.Errorf("unexpected chain message with a plain wrapper %q", msg)
+	}
+
+	// MultiErrors keep their errors, and hold the next argument after them.
+	batch := Append(nil, New("shard 1 failed"), New("shard 2 failed"))
+	chain = Chain(New("sync failed"), batch, third)
+	if links := Flatten(chain); len(links) != 5 || links[4] != third {
+		t.Errorf("unexpected chain with a MultiError %v", links)
+	}
+	if len(batch.(*MultiError).Errors()) != 2 {
+		t.Error("Chain should not modify a MultiError argument")
+	}
+}
+
+func TestLinks(t *testing.T) {
+	inner := NewWithCode("UNAVAILABLE", "connection refused")
+	err := Wrap(fmt.Errorf("query failed: %w", inner), "handler failed")
+
+	expected := []Link{
+		{Msg: "handler failed"},
+		{Msg: "query failed"},
+		{Msg: "connection refused", Code: "UNAVAILABLE"},
+	}
+	if links := Links(err); !reflect.DeepEqual(links, expected) {
+		t.Errorf("unexpected links %v, expected %v", links, expected)
+	}
+	if Links(nil) != nil {
+		t.Error("expected no links for nil error")
+	}
+}
+
+func TestReplaceInner(t *testing.T) {
+	outer := Wrap(fmt.Errorf("connection refused"), "fetch failed").
+		SetState(map[string]interface{}{"user_id": 42})
+	domain := New("user not found")
+
+	replaced := outer.(*DropboxBaseError).ReplaceInner(domain)
+	if replaced.GetMessage() != "fetch failed" {
+		t.Errorf("outer message not preserved: %q", replaced.GetMessage())
+	}
+	if replaced.GetState()["user_id"] != 42 {
+		t.Errorf("outer state not preserved: %v", replaced.GetState())
+	}
+	if replaced.GetInner() != domain {
+		t.Errorf("inner not replaced: %v", replaced.GetInner())
+	}
+
+	// Replacing the inner with an error that wraps the receiver would
+	// create a cycle.
+	cyclic := Wrap(outer, "wrapper")
+	outer.(*DropboxBaseError).ReplaceInner(cyclic)
+	if outer.GetInner() != domain {
+		t.Error("cyclic replacement should be refused")
+	}
+}
+
+func TestDropboxChain(t *testing.T) {
+	leaf := fmt.Errorf("connection refused")
+	middle := Wrap(leaf, "query failed")
+	outer := Wrap(middle, "handler failed")
+
+	chain := DropboxChain(outer)
+	if len(chain) != 2 || chain[0] != outer || chain[1] != middle {
+		t.Errorf("unexpected chain %v", chain)
+	}
+
+	if DropboxChain(leaf) != nil || DropboxChain(nil) != nil {
+		t.Error("expected empty chain for plain and nil errors")
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	context := New("sync failed").SetState(map[string]interface{}{"account": 7})
+	cause := fmt.Errorf("connection reset")
+
+	adopted := Adopt(context, cause)
+	if adopted.GetMessage() != "sync failed" || adopted.GetStack() != context.GetStack() {
+		t.Error("adopted error should inherit the context's message and stack")
+	}
+	if adopted.GetState()["account"] != 7 {
+		t.Errorf("adopted error should inherit the context's state: %v", adopted.GetState())
+	}
+	if adopted.GetInner() != cause {
+		t.Errorf("adopted error should wrap the cause: %v", adopted.GetInner())
+	}
+
+	adopted.GetState()["extra"] = true
+	if _, ok := context.GetState()["extra"]; ok || context.GetInner() != nil {
+		t.Error("context should not be modified")
+	}
+
+	orphan := Adopt(nil, cause)
+	if !stderrors.Is(orphan, cause) || GetMessage(orphan) != "connection reset" {
+		t.Errorf("expected the cause to be wrapped without a context, got %q", GetMessage(orphan))
+	}
+	if Adopt(nil, nil) != nil {
+		t.Error("expected nil without a context and a cause")
+	}
+}
+
+func TestInnerDepth(t *testing.T) {
+	sentinel := fmt.Errorf("not found")
+	middle := Wrap(sentinel, "query failed")
+	outer := Wrap(middle, "handler failed")
+
+	if d := InnerDepth(outer, sentinel); d != 2 {
+		t.Errorf("expected sentinel at depth 2, got %d", d)
+	}
+	if d := InnerDepth(outer, middle); d != 1 {
+		t.Errorf("expected middle at depth 1, got %d", d)
+	}
+	if d := InnerDepth(outer, outer); d != 0 {
+		t.Errorf("expected outer at depth 0, got %d", d)
+	}
+	if d := InnerDepth(outer, fmt.Errorf("not found")); d != -1 {
+		t.Errorf("expected absent target at depth -1, got %d", d)
+	}
+	if d := InnerDepth(nil, sentinel); d != -1 {
+		t.Errorf("expected -1 for nil error, got %d", d)
+	}
+}
+
+func TestSetInnerChain(t *testing.T) {
+	leaf := fmt.Errorf("connection refused")
+	outer := New("handler failed").(*DropboxBaseError)
+	outer.SetInnerChain([]error{New("query failed"), nil, fmt.Errorf("dial failed"), leaf})
+
+	chain := DropboxChain(outer)
+	if len(chain) != 3 {
+		t.Fatalf("unexpected chain length %d", len(chain))
+	}
+	for i, expected := range []string{"handler failed", "query failed", "dial failed"} {
+		if msg := chain[i].GetMessage(); msg != expected {
+			t.Errorf("unexpected message %q at depth %d, expected %q", msg, i, expected)
+		}
+	}
+	if chain[2].GetInner() != leaf {
+		t.Error("the last error should terminate the chain")
+	}
+
+	inner := outer.GetInner()
+	outer.SetInnerChain([]error{Wrap(outer, "cyclic")})
+	outer.SetInnerChain([]error{outer})
+	if outer.GetInner() != inner {
+		t.Error("cyclic and self-referential chains should be refused")
+	}
+}
+
+func TestStdlibWrapping(t *testing.T) {
+	leaf := fmt.Errorf("connection refused")
+	query := Wrap(leaf, "query failed").SetState(map[string]interface{}{"table": "users"})
+	handler := fmt.Errorf("handler: %w", query)
+	outer := Wrap(handler, "request failed")
+
+	if msg := GetMessage(outer); msg != "request failed handler query failed connection refused" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if msg := GetMessage(handler); msg != "handler query failed connection refused" {
+		t.Errorf("unexpected message for plain wrapper %q", msg)
+	}
+	if !ContainsError(outer, leaf) || !ContainsError(outer, query) {
+		t.Error("couldn't confirm that outer contains the errors wrapped with %w")
+	}
+	if RootCause(outer) != leaf {
+		t.Errorf("unexpected root cause %v", RootCause(outer))
+	}
+	if d := InnerDepth(outer, query); d != 2 {
+		t.Errorf("expected query at depth 2, got %d", d)
+	}
+
+	states := outer.GetAnnotatedStates()
+	if len(states) != 4 || states[2]["table"] != "users" || states[1]["_message"] != "handler" {
+		t.Errorf("unexpected annotated states %v", states)
+	}
+
+	s := outer.Error()
+	if strings.Count(s, "MEANINGFUL STACK TRACE") != 1 {
+		t.Errorf("inner error text should not be repeated in:\n%s", s)
+	}
+	if strings.Index(s, "query failed") == -1 || strings.Index(s, `{"table":"users"}`) == -1 {
+		t.Errorf("couldn't find the %%w-wrapped error in:\n%s", s)
+	}
+
+	joined := Wrap(stderrors.Join(New("first"), Wrap(leaf, "second")), "batch failed")
+	if msg := GetMessage(joined); msg != "batch failed first second connection refused" {
+		t.Errorf("unexpected message for joined errors %q", msg)
+	}
+	if len(DropboxChain(joined)) != 3 {
+		t.Errorf("unexpected chain for joined errors %v", DropboxChain(joined))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	healthy := Wrap(fmt.Errorf("handler: %w", Wrap(fmt.Errorf("leaf"), "query failed")), "outer")
+	if err := Validate(healthy); err != nil {
+		t.Errorf("unexpected validation error for a healthy chain: %v", err)
+	}
+	if err := Validate(nil); err != nil {
+		t.Errorf("unexpected validation error for nil: %v", err)
+	}
+
+	var typedNil *DropboxBaseError
+	if err := Validate(Wrap(typedNil, "outer")); err == nil {
+		t.Error("expected a validation error for a typed nil in the chain")
+	}
+
+	// Build a cycle by hand; ReplaceInner would refuse it.
+	first := New("first").(*DropboxBaseError)
+	second := Wrap(first, "second")
+	first.inner = second
+	err := Validate(first)
+	if err == nil || strings.Index(GetMessage(err), "Cycle") == -1 {
+		t.Errorf("expected a cycle validation error, got %v", err)
+	}
+
+	// An error shared by sibling branches isn't a cycle.
+	shared := New("shared")
+	if err := Validate(stderrors.Join(Wrap(shared, "a"), Wrap(shared, "b"))); err != nil {
+		t.Errorf("unexpected validation error for a shared error: %v", err)
+	}
+}
+
+func TestCyclicChainTraversal(t *testing.T) {
+	first := New("first").(*DropboxBaseError)
+	second := Wrap(first, "second")
+	first.inner = second
+
+	if chain := first.inners(); len(chain) != 2 {
+		t.Errorf("expected each error exactly once, got %d errors", len(chain))
+	}
+	if msg := GetMessage(first); msg != "first second" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if !ContainsError(first, second) || ContainsError(first, fmt.Errorf("other")) {
+		t.Error("unexpected ContainsError result on a cyclic chain")
+	}
+	if strings.Index(first.Error(), "second") == -1 {
+		t.Errorf("couldn't find the inner message in:\n%s", first.Error())
+	}
+
+	// Chains longer than the cap are truncated.
+	var long error = fmt.Errorf("leaf")
+	for i := 0; i < 2*maxChainDepth; i++ {
+		long = Wrap(long, "layer")
+	}
+	if chain := walk(long); len(chain) != maxChainDepth {
+		t.Errorf("expected %d errors, got %d", maxChainDepth, len(chain))
+	}
+
+	// The cap is on depth, so wide chains aren't truncated.
+	var errs []error
+	for i := 0; i < 150; i++ {
+		errs = append(errs, fmt.Errorf("failure %d", i))
+	}
+	batch := Wrap(Append(nil, errs...), "batch")
+	if !ContainsError(batch, errs[149]) {
+		t.Error("expected the last error of the batch to be found")
+	}
+	if InnerDepth(batch, errs[149]) == -1 {
+		t.Error("expected a depth for the last error of the batch")
+	}
+	if messages := GetMessages(batch); len(messages) < 150 {
+		t.Errorf("expected all messages of the batch, got %d", len(messages))
+	}
+}
+
+func TestSetInnerPreventsCycles(t *testing.T) {
+	leaf := fmt.Errorf("leaf")
+	first := New("first").(*DropboxBaseError)
+	second := Wrap(fmt.Errorf("handler: %w", first), "second")
+
+	if first.setInner(second) {
+		t.Error("setInner should refuse an inner error wrapping the receiver")
+	}
+	if first.setInner(first) {
+		t.Error("setInner should refuse the receiver itself")
+	}
+	if !first.setInner(leaf) || first.GetInner() != leaf {
+		t.Error("setInner should accept an unrelated inner error")
+	}
+
+	first.ReplaceInner(second)
+	first.SetInnerChain([]error{New("x"), second})
+	if first.GetInner() != leaf {
+		t.Error("ReplaceInner and SetInnerChain should refuse cycles")
+	}
+	if err := Validate(Wrap(second, "outer")); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
+
+func TestRootCause(t *testing.T) {
+	leaf := &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}
+	err := Wrap(fmt.Errorf("query failed: %w", Wrap(leaf, "connect failed")), "handler failed")
+	if cause := RootCause(err); cause != leaf.Err {
+		t.Errorf("unexpected root cause %v", cause)
+	}
+	opErr := Wrap(&net.OpError{Op: "dial", Net: "tcp"}, "connect failed")
+	if _, ok := RootCause(opErr).(*net.OpError); !ok {
+		t.Errorf("expected the *net.OpError as root cause, got %T", RootCause(opErr))
+	}
+
+	plain := fmt.Errorf("plain")
+	if RootCause(plain) != plain {
+		t.Error("expected a plain error to be its own root cause")
+	}
+	if RootCause(nil) != nil {
+		t.Error("expected no root cause for nil")
+	}
+
+	first := New("first").(*DropboxBaseError)
+	second := Wrap(first, "second")
+	first.inner = second
+	if cause := RootCause(second); cause != first {
+		t.Errorf("expected the traversal of a cycle to stop, got %v", cause)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	leaf := fmt.Errorf("connection refused")
+	query := fmt.Errorf("query failed: %w", leaf)
+	connect := WrapOpt(query, "connect failed", WithCode("UNAVAILABLE"))
+	err := Wrap(connect, "handler failed")
+
+	chain := Flatten(err)
+	if len(chain) != 4 || chain[0] != err || chain[1] != connect || chain[2] != query || chain[3] != leaf {
+		t.Errorf("unexpected chain %v", chain)
+	}
+	var codes []string
+	for _, e := range chain {
+		if dbe, ok := e.(DropboxError); ok {
+			codes = append(codes, dbe.GetCode())
+		}
+	}
+	if strings.Join(codes, ",") != "UNAVAILABLE,UNAVAILABLE" {
+		t.Errorf("unexpected codes %v", codes)
+	}
+
+	first := New("first").(*DropboxBaseError)
+	second := Wrap(first, "second")
+	first.inner = second
+	if chain := Flatten(second); len(chain) != 2 {
+		t.Errorf("expected each error of a cycle once, got %v", chain)
+	}
+	if Flatten(nil) != nil {
+		t.Error("expected no chain for nil")
+	}
+}
+
+func TestLinkMessageStripsWrappedText(t *testing.T) {
+	err := fmt.Errorf("timeout while reading: %w", fmt.Errorf("timeout"))
+	if msgs := GetMessages(err); len(msgs) != 2 || msgs[0] != "timeout while reading" || msgs[1] != "timeout" {
+		t.Errorf("unexpected messages %q", msgs)
+	}
+
+	both := fmt.Errorf("%w: %w", fmt.Errorf("a"), fmt.Errorf("a"))
+	if msg := linkMessage(both); msg != "" {
+		t.Errorf("unexpected message for several wrapped errors %q", msg)
+	}
+}
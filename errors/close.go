@@ -0,0 +1,33 @@
+package errors
+
+import (
+	"io"
+)
+
+// CloseAndWrap closes closer, and records its error (if any) wrapped with
+// msg into *errp.  It is meant to be deferred by functions with a named error
+// result, e.g.:
+//
+//	func readConfig(path string) (err error) {
+//		f, err := os.Open(path)
+//		if err != nil {
+//			return err
+//		}
+//		defer errors.CloseAndWrap(&err, f, "closing config")
+//		...
+//	}
+//
+// If *errp already holds an error, it remains the primary one and the Close
+// error is appended to it as a secondary error (see Append).
+func CloseAndWrap(errp *error, closer io.Closer, msg string) {
+	cerr := closer.Close()
+	if cerr == nil {
+		return
+	}
+	wrapped := newWithOptions(msg, cerr, nil)
+	if *errp == nil {
+		*errp = wrapped
+		return
+	}
+	*errp = Append(*errp, wrapped)
+}
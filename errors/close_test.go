@@ -0,0 +1,38 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type fakeCloser struct{ err error }
+
+func (c fakeCloser) Close() error { return c.err }
+
+func TestCloseAndWrap(t *testing.T) {
+	cerr := fmt.Errorf("disk full")
+
+	var err error
+	CloseAndWrap(&err, fakeCloser{cerr}, "closing file")
+	if !ContainsError(err, cerr) || GetMessage(err) != "closing file disk full" {
+		t.Errorf("expected the close error to be wrapped, got %q", GetMessage(err))
+	}
+	if strings.Index(err.(DropboxError).GetStack(), "TestCloseAndWrap") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", err.(DropboxError).GetStack())
+	}
+
+	primary := New("write failed")
+	err = primary
+	CloseAndWrap(&err, fakeCloser{cerr}, "closing file")
+	m, ok := err.(*MultiError)
+	if !ok || len(m.Errors()) != 2 || m.Errors()[0] != primary || !ContainsError(m.Errors()[1], cerr) {
+		t.Errorf("expected the close error to be appended to the primary error, got %v", err)
+	}
+
+	err = primary
+	CloseAndWrap(&err, fakeCloser{}, "closing file")
+	if err != primary {
+		t.Errorf("expected the error to be unchanged when Close succeeds, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Message used by WrapOp when the context holds no operation name.
+const unknownOpMessage = "operation failed"
+
+// WrapOp wraps err with the name of the operation stored in ctx under opKey
+// as the message, e.g. the operation name recorded for tracing.  Falls back
+// to a generic message if ctx holds no operation name.
+func WrapOp(ctx context.Context, err error, opKey interface{}) DropboxError {
+	msg := unknownOpMessage
+	if ctx != nil {
+		if op := ctx.Value(opKey); op != nil {
+			msg = fmt.Sprint(op)
+		}
+	}
+
+	return enrich(&DropboxBaseError{
+		Msg:   sanitizeMessage(msg),
+		inner: err,
+		pcs:   callers(),
+	})
+}
+
+// A SpanExtractor returns the trace and span ids of the span in ctx, if any.
+type SpanExtractor func(ctx context.Context) (traceID, spanID string)
+
+var (
+	spanExtractorMutex sync.RWMutex
+	spanExtractor      SpanExtractor
+)
+
+// SetSpanExtractor sets the function WrapSpan uses to read trace and span
+// ids from a context.  This keeps tracing libraries (e.g. OpenTelemetry) out
+// of this package's dependencies.
+func SetSpanExtractor(extractor SpanExtractor) {
+	spanExtractorMutex.Lock()
+	defer spanExtractorMutex.Unlock()
+	spanExtractor = extractor
+}
+
+// WrapSpan is the same as Wrap, but also stores the ids of the trace and
+// span in ctx (see SetSpanExtractor) in the new error's state, under
+// "_trace_id" and "_span_id", for correlation with traces.
+func WrapSpan(ctx context.Context, err error, msg string) DropboxError {
+	e := &DropboxBaseError{
+		Msg:   sanitizeMessage(msg),
+		inner: err,
+		pcs:   callers(),
+	}
+
+	spanExtractorMutex.RLock()
+	extractor := spanExtractor
+	spanExtractorMutex.RUnlock()
+	if extractor != nil && ctx != nil {
+		traceID, spanID := extractor(ctx)
+		if traceID != "" || spanID != "" {
+			e.State = map[string]interface{}{
+				"_trace_id": traceID,
+				"_span_id":  spanID,
+			}
+		}
+	}
+
+	return enrich(e)
+}
@@ -0,0 +1,69 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type opKeyType struct{}
+
+func TestWrapOp(t *testing.T) {
+	cause := fmt.Errorf("connection refused")
+	ctx := context.WithValue(context.Background(), opKeyType{}, "users.Get")
+
+	err := WrapOp(ctx, cause, opKeyType{})
+	if err.GetMessage() != "users.Get" {
+		t.Errorf("expected the operation name as message, got %q", err.GetMessage())
+	}
+	if err.GetInner() != cause {
+		t.Error("WrapOp should wrap the error")
+	}
+	if strings.Index(err.GetStack(), "TestWrapOp") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", err.GetStack())
+	}
+
+	err = WrapOp(context.Background(), cause, opKeyType{})
+	if err.GetMessage() != unknownOpMessage {
+		t.Errorf("expected the fallback message, got %q", err.GetMessage())
+	}
+}
+
+type spanKeyType struct{}
+
+func TestWrapSpan(t *testing.T) {
+	defer SetSpanExtractor(nil)
+	cause := fmt.Errorf("connection refused")
+	ctx := context.WithValue(context.Background(), spanKeyType{}, "trace-1/span-2")
+
+	err := WrapSpan(ctx, cause, "query failed")
+	if err.GetState() != nil {
+		t.Errorf("expected no state without an extractor, got %v", err.GetState())
+	}
+
+	SetSpanExtractor(func(ctx context.Context) (string, string) {
+		ids, _ := ctx.Value(spanKeyType{}).(string)
+		if parts := strings.SplitN(ids, "/", 2); len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+		return "", ""
+	})
+
+	err = WrapSpan(ctx, cause, "query failed")
+	if err.GetMessage() != "query failed" || err.GetInner() != cause {
+		t.Errorf("WrapSpan should wrap the error:\n%s", err.Error())
+	}
+	state := err.GetState()
+	if state["_trace_id"] != "trace-1" || state["_span_id"] != "span-2" {
+		t.Errorf("unexpected span state %v", state)
+	}
+	if strings.Index(err.GetStack(), "TestWrapSpan") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", err.GetStack())
+	}
+
+	err = WrapSpan(context.Background(), cause, "query failed")
+	if err.GetState() != nil {
+		t.Errorf("expected no state without a span, got %v", err.GetState())
+	}
+}
@@ -0,0 +1,50 @@
+package errors
+
+import (
+	"sort"
+)
+
+// DumpRegistrations returns a snapshot of the package's registries, for
+// diagnostics endpoints (e.g. to find out why an error was routed to some
+// channel).  It holds:
+//
+//	"category_channels":  map[string]string, see RegisterCategoryChannel
+//	"cached_errors":      []string, the sorted keys passed to CachedNew
+//	"enrichers":          int, the number of registered enrichers
+//	"normalize_patterns": []string, "pattern -> placeholder" in order
+//
+// The snapshot doesn't share memory with the registries.
+func DumpRegistrations() map[string]interface{} {
+	categoryChannelsMutex.RLock()
+	channels := make(map[string]string, len(categoryChannels))
+	for category, channel := range categoryChannels {
+		channels[category] = channel
+	}
+	categoryChannelsMutex.RUnlock()
+
+	cachedErrorsMutex.RLock()
+	cached := make([]string, 0, len(cachedErrors))
+	for key := range cachedErrors {
+		cached = append(cached, key)
+	}
+	cachedErrorsMutex.RUnlock()
+	sort.Strings(cached)
+
+	enrichersMutex.RLock()
+	numEnrichers := len(enrichers)
+	enrichersMutex.RUnlock()
+
+	normalizePatternsMutex.RLock()
+	patterns := make([]string, 0, len(normalizePatterns))
+	for _, p := range normalizePatterns {
+		patterns = append(patterns, p.Pattern.String()+" -> "+p.Placeholder)
+	}
+	normalizePatternsMutex.RUnlock()
+
+	return map[string]interface{}{
+		"category_channels":  channels,
+		"cached_errors":      cached,
+		"enrichers":          numEnrichers,
+		"normalize_patterns": patterns,
+	}
+}
@@ -0,0 +1,47 @@
+package errors
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestDumpRegistrations(t *testing.T) {
+	defer func() { categoryChannels = make(map[string]string) }()
+	defer SetNormalizePatterns(DefaultNormalizePatterns())
+	saved := enrichers
+	defer func() { enrichers = saved }()
+	enrichers = nil
+
+	RegisterCategoryChannel("billing", "#billing-alerts")
+	CachedNew("dump-b", "b")
+	CachedNew("dump-a", "a")
+	RegisterEnricher(func(e DropboxError) DropboxError { return e })
+	SetNormalizePatterns([]NormalizePattern{{regexp.MustCompile(`\d+`), "<n>"}})
+
+	dump := DumpRegistrations()
+	if channels := dump["category_channels"]; !reflect.DeepEqual(channels, map[string]string{"billing": "#billing-alerts"}) {
+		t.Errorf("unexpected category channels %v", channels)
+	}
+	cached := dump["cached_errors"].([]string)
+	for i, key := range cached {
+		if i > 0 && cached[i-1] > key {
+			t.Errorf("expected sorted cached error keys, got %v", cached)
+		}
+	}
+	if !containsString(cached, "dump-a") || !containsString(cached, "dump-b") {
+		t.Errorf("expected the cached error keys in %v", cached)
+	}
+	if n := dump["enrichers"]; n != 1 {
+		t.Errorf("unexpected number of enrichers %v", n)
+	}
+	if patterns := dump["normalize_patterns"]; !reflect.DeepEqual(patterns, []string{`\d+ -> <n>`}) {
+		t.Errorf("unexpected normalize patterns %v", patterns)
+	}
+
+	// The dump is a snapshot.
+	dump["category_channels"].(map[string]string)["billing"] = "#other"
+	if ch := AlertChannel(WrapCategory(nil, "billing", "invoice failed")); ch != "#billing-alerts" {
+		t.Errorf("modifying the dump should not change the registry, got %q", ch)
+	}
+}
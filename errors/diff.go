@@ -0,0 +1,47 @@
+package errors
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Diff returns a human readable description of the differences between the
+// chains of a and b, or "" if they are equivalent.  Each level of the chains
+// is compared by message, code and state; stacks are ignored.  This is meant for
+// asserting that a refactoring didn't change error behavior.
+func Diff(a, b error) string {
+	chainA := walk(a)
+	chainB := walk(b)
+
+	var diffs []string
+	for i := 0; i < len(chainA) || i < len(chainB); i++ {
+		if i >= len(chainA) {
+			diffs = append(diffs, fmt.Sprintf("level %d: missing in a, b has %q", i, linkMessage(chainB[i])))
+			continue
+		}
+		if i >= len(chainB) {
+			diffs = append(diffs, fmt.Sprintf("level %d: missing in b, a has %q", i, linkMessage(chainA[i])))
+			continue
+		}
+
+		if msgA, msgB := linkMessage(chainA[i]), linkMessage(chainB[i]); msgA != msgB {
+			diffs = append(diffs, fmt.Sprintf("level %d: message %q != %q", i, msgA, msgB))
+		}
+		if codeA, codeB := linkCode(chainA[i]), linkCode(chainB[i]); codeA != codeB {
+			diffs = append(diffs, fmt.Sprintf("level %d: code %q != %q", i, codeA, codeB))
+		}
+		if stateA, stateB := linkState(chainA[i]), linkState(chainB[i]); !reflect.DeepEqual(stateA, stateB) {
+			diffs = append(diffs, fmt.Sprintf("level %d: state %v != %v", i, stateA, stateB))
+		}
+	}
+	return strings.Join(diffs, "\n")
+}
+
+// Returns err's own state, treating empty state as nil.
+func linkState(err error) map[string]interface{} {
+	if dbe, ok := err.(DropboxError); ok && len(dbe.GetState()) > 0 {
+		return dbe.GetState()
+	}
+	return nil
+}
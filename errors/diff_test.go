@@ -0,0 +1,45 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	build := func(innerMsg string, userID int) error {
+		inner := Wrap(fmt.Errorf("%s", innerMsg), "query failed")
+		return Wrap(inner, "handler failed").SetState(map[string]interface{}{"user_id": userID})
+	}
+
+	// Same chains created at different call sites.
+	a := build("connection refused", 42)
+	b := Wrap(Wrap(fmt.Errorf("connection refused"), "query failed"), "handler failed").
+		SetState(map[string]interface{}{"user_id": 42})
+	if d := Diff(a, b); d != "" {
+		t.Errorf("expected no diff for identical chains, got:\n%s", d)
+	}
+	if d := Diff(nil, nil); d != "" {
+		t.Errorf("expected no diff for nil errors, got:\n%s", d)
+	}
+
+	d := Diff(a, build("timeout", 7))
+	for _, expected := range []string{
+		`level 0: state map[user_id:42] != map[user_id:7]`,
+		`level 2: message "connection refused" != "timeout"`,
+	} {
+		if strings.Index(d, expected) == -1 {
+			t.Errorf("couldn't find %q in diff:\n%s", expected, d)
+		}
+	}
+
+	d = Diff(a, Wrap(fmt.Errorf("connection refused"), "query failed"))
+	if strings.Index(d, `level 2: missing in b, a has "connection refused"`) == -1 {
+		t.Errorf("expected a missing level in diff:\n%s", d)
+	}
+
+	d = Diff(New("rate limited").WithCode("RATE_LIMITED"), New("rate limited"))
+	if d != `level 0: code "RATE_LIMITED" != ""` {
+		t.Errorf("expected a code difference in diff:\n%s", d)
+	}
+}
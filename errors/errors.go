@@ -8,8 +8,11 @@ package errors
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"unicode/utf8"
 	"encoding/json"
 )
 
@@ -37,14 +40,34 @@ type DropboxError interface {
 	// This returns the state of the error.
 	GetState() map[string]interface{}
 
+	// This adds a single key to the state of the error.
+	WithField(key string, value interface{}) DropboxError
+
+	// This adds the given keys to the state of the error.
+	WithFields(fields map[string]interface{}) DropboxError
+
 	// This returns the state of the error and all inner errors.
 	GetAnnotatedStates() []map[string]interface{}
+
+	// This returns the error's stable code (e.g. "RATE_LIMITED"), falling
+	// back to the first code found among the inner errors, or "" if there is
+	// none.
+	GetCode() string
+
+	// This sets the error's code.
+	WithCode(code string) DropboxError
 }
 
 // Standard struct for general types of errors.
 //
 // For an example of custom error type, look at databaseError/newDatabaseError
 // in errors_test.go.
+//
+// NOTE: State is guarded by an internal lock; use the methods (GetState,
+// SetState, WithField, ...) rather than the field when the error may be
+// shared across goroutines.  Stack is only filled in from the program
+// counters captured at creation when first needed (by GetStack, Error,
+// Format, ...), so read it through GetStack.
 type DropboxBaseError struct {
 	Msg      string
 	Stack    string
@@ -52,91 +75,539 @@ type DropboxBaseError struct {
 	State    map[string]interface{}
 	Constant bool
 	inner    error
+
+	namedStacks map[string]string
+	category    string
+	expected    bool
+	code        string
+	httpStatus  int
+	// gRPC codes.Code, stored without depending on grpc (see grpc.go).
+	grpcCode    uint32
+	grpcCodeSet bool
+	// Whether the failure is worth retrying, if retryableSet (see retry.go).
+	retryable    bool
+	retryableSet bool
+
+	// Guards State, namedStacks, code, httpStatus, grpcCode and retryable,
+	// which may be shared across goroutines.
+	stateMutex sync.RWMutex
+
+	// Program counters of the stack captured at creation, which are only
+	// symbolized (into frames and Stack) when first needed.
+	pcs           []uintptr
+	frames        []runtime.Frame
+	symbolizeOnce sync.Once
+}
+
+// Returns a shallow copy of e.  The state map is copied, other maps are shared
+// with e.
+func (e *DropboxBaseError) copy() *DropboxBaseError {
+	c := &DropboxBaseError{
+		Msg:         e.Msg,
+		Stack:       e.GetStack(),
+		Context:     e.Context,
+		State:       e.copyState(),
+		Constant:    e.Constant,
+		inner:       e.inner,
+		namedStacks: e.copyNamedStacks(),
+		category:    e.category,
+		expected:    e.expected,
+		code:        e.ownCode(),
+		httpStatus:  e.ownHTTPStatus(),
+		pcs:         e.pcs,
+	}
+	c.grpcCode, c.grpcCodeSet = e.ownGRPCCode()
+	c.retryable, c.retryableSet = e.ownRetryable()
+	return c
+}
+
+// An Enricher is invoked on every newly constructed error, after its stack has
+// been captured.  It returns the (possibly modified) error.
+type Enricher func(DropboxError) DropboxError
+
+var (
+	enrichersMutex sync.RWMutex
+	enrichers      []Enricher
+)
+
+// RegisterEnricher appends an enricher to the global enrichment pipeline.
+// Enrichers are run in registration order by all error constructors, which
+// makes them the place to attach cross-cutting metadata (hostname, service
+// name, build SHA, ...).
+func RegisterEnricher(enricher Enricher) {
+	enrichersMutex.Lock()
+	defer enrichersMutex.Unlock()
+	enrichers = append(enrichers, enricher)
 }
 
-// This returns the error string without stack trace information.
+// Runs err through all registered enrichers.
+func enrich(err DropboxError) DropboxError {
+	enrichersMutex.RLock()
+	defer enrichersMutex.RUnlock()
+	for _, enricher := range enrichers {
+		err = enricher(err)
+	}
+	return err
+}
+
+// When set, invalid UTF-8 in messages is replaced at construction.
+var sanitizeMessages = false
+
+// SetSanitizeMessages controls whether error constructors replace invalid
+// UTF-8 sequences in messages (e.g. from binary data) with the Unicode
+// replacement character, so that the messages can't break JSON logging.
+// This should be called during initialization.
+func SetSanitizeMessages(sanitize bool) {
+	sanitizeMessages = sanitize
+}
+
+func sanitizeMessage(msg string) string {
+	if sanitizeMessages && !utf8.ValidString(msg) {
+		return strings.ToValidUTF8(msg, string(utf8.RuneError))
+	}
+	return msg
+}
+
+// NOTE: All DropboxBaseError methods are safe to call on a nil receiver and
+// return zero values, so that an accidental typed-nil error (see IsNil) does
+// not crash the code that is trying to log it.
+
+// This returns the error string without stack trace information.  The
+// messages of all errors in the chain are joined, including errors wrapped
+// with the standard library's conventions (e.g. fmt.Errorf's %w verb).
 func GetMessage(err interface{}) string {
+	return GetMessageSep(err, " ")
+}
+
+// Same as GetMessage, but the messages are joined with sep, e.g. ": " for
+// "handler failed: query failed: connection refused".
+func GetMessageSep(err interface{}, sep string) string {
 	switch e := err.(type) {
 	case DropboxError:
-		dberr := DropboxError(e)
-		ret := []string{}
-		for dberr != nil {
-			ret = append(ret, dberr.GetMessage())
-			d := dberr.GetInner()
-			if d == nil {
-				break
-			}
-			var ok bool
-			dberr, ok = d.(DropboxError)
-			if !ok {
-				ret = append(ret, d.Error())
-				break
-			}
-		}
-		return strings.Join(ret, " ")
+		return joinMessages(e, sep)
 	case runtime.Error:
 		return runtime.Error(e).Error()
+	case error:
+		return joinMessages(e, sep)
 	default:
 		return "Passed a non-error to GetMessage"
 	}
 }
 
+func joinMessages(err error, sep string) string {
+	return strings.Join(GetMessages(err), sep)
+}
+
+// GetMessages returns the messages of err's chain, one per level, outermost
+// first, i.e. what GetMessage joins.  Errors which aren't DropboxErrors
+// contribute their Error() text, minus the text of the errors they wrap.
+// Levels without a message of their own are skipped.  Returns nil for a nil
+// error.
+func GetMessages(err error) []string {
+	var ret []string
+	for _, e := range walk(err) {
+		if msg := linkMessage(e); msg != "" {
+			ret = append(ret, msg)
+		}
+	}
+	return ret
+}
+
 // This returns a string with all available error information, including inner
 // errors that are wrapped by this errors.
 func (e *DropboxBaseError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	e.symbolize()
 	return DefaultError(e)
 }
 
 // This returns the error message without the stack trace.
 func (e *DropboxBaseError) GetMessage() string {
+	if e == nil {
+		return ""
+	}
 	return e.Msg
 }
 
 // This returns the stack trace without the error message.
 func (e *DropboxBaseError) GetStack() string {
+	if e == nil {
+		return ""
+	}
+	e.symbolize()
 	return e.Stack
 }
 
 // This returns the stack trace's context.
 func (e *DropboxBaseError) GetContext() string {
+	if e == nil {
+		return ""
+	}
 	return e.Context
 }
 
 // This returns the wrapped error, if there is one.
 func (e *DropboxBaseError) GetInner() error {
+	if e == nil {
+		return nil
+	}
+	return e.inner
+}
+
+// This returns the wrapped error, like GetInner, so that the standard
+// library's errors.Is, errors.As and errors.Unwrap traverse the chain.
+func (e *DropboxBaseError) Unwrap() error {
+	if e == nil || e.inner == nil {
+		return nil
+	}
 	return e.inner
 }
 
+// This returns true if target is anywhere in the chain of errors wrapped by
+// e, so that sentinel errors can be matched with the standard library's
+// errors.Is no matter how many times they've been wrapped.  Errors are
+// compared with ==, or with their own Is method.
+func (e *DropboxBaseError) Is(target error) bool {
+	if e == nil || e.inner == nil {
+		return false
+	}
+	for _, err := range walk(e.inner) {
+		if matches(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// This finds the first error in e's chain (including e itself) which is
+// assignable to the value pointed to by target, and if found, sets target to
+// that error and returns true.  This makes the standard library's errors.As
+// work for custom error types wrapped anywhere in the chain.
+//
+// As with errors.As, this panics if target is not a non-nil pointer to
+// either a type that implements error, or to any interface type.
+func (e *DropboxBaseError) As(target interface{}) bool {
+	if target == nil {
+		panic("errors: target cannot be nil")
+	}
+	val := reflect.ValueOf(target)
+	typ := val.Type()
+	if typ.Kind() != reflect.Ptr || val.IsNil() {
+		panic("errors: target must be a non-nil pointer")
+	}
+	targetType := typ.Elem()
+	if targetType.Kind() != reflect.Interface && !targetType.Implements(errorType) {
+		panic("errors: *target must be interface or implement error")
+	}
+
+	if e == nil {
+		return false
+	}
+	for _, err := range walk(e) {
+		if reflect.TypeOf(err).AssignableTo(targetType) {
+			val.Elem().Set(reflect.ValueOf(err))
+			return true
+		}
+		if _, ok := err.(DropboxError); ok {
+			continue
+		}
+		if x, ok := err.(interface{ As(interface{}) bool }); ok && x.As(target) {
+			return true
+		}
+	}
+	return false
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 func (e *DropboxBaseError) SetState(s map[string]interface{}) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
 	e.State = s
 	return e
 }
 
+// This adds a key to the state of the error (initializing it if needed) and
+// returns the error for chaining, e.g.:
+//
+//	errors.New("boom").WithField("user_id", 42).WithField("attempt", 3)
+func (e *DropboxBaseError) WithField(key string, value interface{}) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	if e.State == nil {
+		e.State = make(map[string]interface{})
+	}
+	e.State[key] = value
+	return e
+}
+
+// Same as WithField, but adds several keys at once.
+func (e *DropboxBaseError) WithFields(fields map[string]interface{}) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	if e.State == nil {
+		e.State = make(map[string]interface{}, len(fields))
+	}
+	for key, value := range fields {
+		e.State[key] = value
+	}
+	return e
+}
+
+// This appends ": " + extra to the error's own message and returns the error
+// for chaining, for details which belong to the same logical error rather
+// than to a new level of the chain.
+func (e *DropboxBaseError) AppendMessage(extra string) DropboxError {
+	if e == nil {
+		return nil
+	}
+	if e.Msg == "" {
+		e.Msg = sanitizeMessage(extra)
+	} else {
+		e.Msg += ": " + sanitizeMessage(extra)
+	}
+	return e
+}
+
+// This sets the error's code, which identifies the kind of failure
+// independently of the human-readable message (e.g. for RPC status
+// mapping), and returns the error for chaining.  A code overrides the codes
+// of the inner errors.
+func (e *DropboxBaseError) WithCode(code string) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	e.code = code
+	return e
+}
+
+// This returns the error's code, or the first code found among its inner
+// errors if it has none.  See GetCode.
+func (e *DropboxBaseError) GetCode() string {
+	if e == nil {
+		return ""
+	}
+	return GetCode(e)
+}
+
+// Returns the code set on e itself, ignoring inner errors.
+func (e *DropboxBaseError) ownCode() string {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	return e.code
+}
+
+// Returns the gRPC code set on e itself, and whether one is set.
+func (e *DropboxBaseError) ownGRPCCode() (uint32, bool) {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	return e.grpcCode, e.grpcCodeSet
+}
+
+// GetCode returns the first (i.e. outermost) code found in err's chain, or
+// "" if there is none.  Wrapping an error thus preserves its code unless the
+// wrapper sets its own, e.g.:
+//
+//	if errors.GetCode(err) == "RATE_LIMITED" { ... }
+func GetCode(err error) string {
+	for _, e := range walk(err) {
+		if code := linkCode(e); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+// MostSevereCode returns the code of err's chain ranked highest by severity
+// (e.g. ranking "FATAL" above "ERROR" above "WARN"), rather than the
+// outermost one as GetCode does.  Ties go to the outermost code.  Returns ""
+// if the chain has no code.
+func MostSevereCode(err error, severity func(code string) int) string {
+	var worst string
+	var worstSeverity int
+	for _, e := range walk(err) {
+		code := linkCode(e)
+		if code == "" {
+			continue
+		}
+		if s := severity(code); worst == "" || s > worstSeverity {
+			worst, worstSeverity = code, s
+		}
+	}
+	return worst
+}
+
+// Returns the code err contributes to its chain, i.e. ignoring the codes of
+// the errors it wraps (as far as err's type allows).
+func linkCode(err error) string {
+	switch e := err.(type) {
+	case *DropboxBaseError:
+		return e.ownCode()
+	case *MultiError:
+		if e == nil {
+			return ""
+		}
+		return e.base.ownCode()
+	case interface{ GetCode() string }:
+		return e.GetCode()
+	}
+	return ""
+}
+
+// This returns a copy of the error whose state is e's state merged with the
+// given state (keys in state win), leaving e untouched.  Unlike SetState, this
+// is safe to use on errors shared across goroutines or stored in sentinels,
+// and is the preferred way to attach state.  E.g.:
+//
+//	err := errors.New("boom").SetState(map[string]interface{}{"a": 1})
+//	err2 := err.(*errors.DropboxBaseError).WithState(map[string]interface{}{"b": 2})
+//	// err's state is still {"a": 1}, err2's state is {"a": 1, "b": 2}.
+func (e *DropboxBaseError) WithState(state map[string]interface{}) DropboxError {
+	if e == nil {
+		return nil
+	}
+	c := e.copy()
+	if c.State == nil {
+		c.State = make(map[string]interface{}, len(state))
+	}
+	for key, value := range state {
+		c.State[key] = value
+	}
+	return c
+}
+
+// This returns a copy of the error's state, so that it can't be modified
+// without synchronization.
 func (e *DropboxBaseError) GetState() map[string]interface{} {
-	return e.State
+	if e == nil {
+		return nil
+	}
+	return e.copyState()
+}
+
+// Returns a shallow copy of e.State.
+func (e *DropboxBaseError) copyState() map[string]interface{} {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	if e.State == nil {
+		return nil
+	}
+	state := make(map[string]interface{}, len(e.State))
+	for key, value := range e.State {
+		state[key] = value
+	}
+	return state
+}
+
+// AddNamedStack records the current stack trace under the given name, which
+// is useful for errors associated with several call sites (e.g. where an
+// async task was submitted, executed, and called back).
+func (e *DropboxBaseError) AddNamedStack(name string) DropboxError {
+	if e == nil {
+		return nil
+	}
+	stack, _ := StackTrace()
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	if e.namedStacks == nil {
+		e.namedStacks = make(map[string]string)
+	}
+	e.namedStacks[name] = stack
+	return e
+}
+
+// This returns a copy of the stacks recorded by AddNamedStack, by name.
+func (e *DropboxBaseError) GetNamedStacks() map[string]string {
+	if e == nil {
+		return nil
+	}
+	if stacks := e.copyNamedStacks(); stacks != nil {
+		return stacks
+	}
+	return make(map[string]string)
 }
 
-func (e *DropboxBaseError) GetAnnotatedStates() (out []map[string]interface{}) {
-	for _, err := range e.inners() {
+// Returns a copy of e's named stacks, or nil if there are none.
+func (e *DropboxBaseError) copyNamedStacks() map[string]string {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	if e.namedStacks == nil {
+		return nil
+	}
+	out := make(map[string]string, len(e.namedStacks))
+	for name, stack := range e.namedStacks {
+		out[name] = stack
+	}
+	return out
+}
+
+// A plain, exported view of a DropboxBaseError, which is easy to inspect in
+// a debugger or compare in a test.
+type ErrorSnapshot struct {
+	Message string
+	// Code set on the error itself, if any (see WithCode).
+	Code string
+	// First frame of the error's stack, if any.
+	TopFrame StackFrame
+	// Copy of the error's state.
+	State map[string]interface{}
+	// Message of the wrapped error, if any.
+	InnerMessage string
+}
+
+// This returns a snapshot of the error's own fields.
+func (e *DropboxBaseError) Snapshot() ErrorSnapshot {
+	if e == nil {
+		return ErrorSnapshot{}
+	}
+
+	snapshot := ErrorSnapshot{Message: e.Msg, Code: e.ownCode()}
+	if frames := e.stackFrames(); len(frames) > 0 {
+		snapshot.TopFrame = frames[0]
+	}
+	snapshot.State = e.copyState()
+	if e.inner != nil {
+		snapshot.InnerMessage = linkMessage(e.inner)
+	}
+	return snapshot
+}
+
+func (e *DropboxBaseError) GetAnnotatedStates() []map[string]interface{} {
+	if e == nil {
+		return nil
+	}
+	return annotatedStates(e)
+}
+
+// Returns the state of each error of err's chain, annotated with its message
+// and location.
+func annotatedStates(err error) (out []map[string]interface{}) {
+	for _, err := range walk(err) {
 		var s map[string]interface{}
 		if dbe, ok := err.(DropboxError); ok {
-			s = dbe.GetState()
-			if s == nil {
-				s = make(map[string]interface{})
-			}
-			stack := dbe.GetStack()
-			if end := IndexNth(stack, "\n", 3); end >= 0 {
-				stack = stack[:end]
-			}
-			if beg := strings.LastIndex(stack, "\n"); beg >= 0 {
-				stack = stack[beg:]
+			// Copy the state, so that the annotations don't leak into it.
+			s = make(map[string]interface{})
+			for key, value := range renderedState(dbe.GetState()) {
+				s[key] = value
 			}
-			stack = strings.TrimSpace(stack)
-			s["_location"] = stack
-			s["_message"] = dbe.GetMessage()
+			s["_location"] = topFrame(errorFrames(dbe))
+			s["_message"] = linkMessage(dbe)
 		} else {
 			s = map[string]interface{}{
-				"_message": err.Error(),
+				"_message": linkMessage(err),
 			}
 		}
 
@@ -147,73 +618,190 @@ func (e *DropboxBaseError) GetAnnotatedStates() (out []map[string]interface{}) {
 }
 
 func (e *DropboxBaseError) inners() (out []error) {
-	var err error = e
-	for {
-		if err != nil {
-			out = append(out, err)
-		}
-
-		if dbe, ok := err.(DropboxError); ok {
-			err = dbe.GetInner()
-		} else {
-			return
-		}
-	}
+	return walk(e)
 }
 
 // This returns a new DropboxBaseError initialized with the given message and
 // the current stack trace.
 func New(msg string) DropboxError {
-	stack, context := StackTrace()
-	return &DropboxBaseError{
-		Msg:     msg,
-		Stack:   stack,
-		Context: context,
-	}
+	return newWithOptions(msg, nil, nil)
+}
+
+// NewAllStacks is the same as New, but the error's context holds the stack
+// traces of ALL goroutines, which helps when debugging deadlocks.
+//
+// NOTE: This is expensive (it stops the world), so use it sparingly.
+func NewAllStacks(msg string) DropboxError {
+	return enrich(&DropboxBaseError{
+		Msg:     sanitizeMessage(msg),
+		Context: allStacks(),
+		pcs:     callers(),
+	})
 }
 
 // NewConstant returns an error that is to be used as a constant instead of necessarily having valid
 // stack information itself (e.g. used for NotFound-type errors that are created as constants.)
 func NewConstant(msg string) DropboxError {
 	e := New(msg)
-	e.(*DropboxBaseError).Constant = true
+	if dbe, ok := e.(*DropboxBaseError); ok {
+		dbe.Constant = true
+	}
 	return e
 }
 
+// NewSentinel is a cheap variant of NewConstant which doesn't capture a
+// stack at all, e.g. for sentinel errors created at package initialization
+// (var ErrClosed = errors.NewSentinel("closed")), or errors created and
+// discarded in hot paths.  GetStack and GetContext return "" for such errors.
+func NewSentinel(msg string) DropboxError {
+	return enrich(&DropboxBaseError{
+		Msg:      sanitizeMessage(msg),
+		Constant: true,
+	})
+}
+
+var (
+	cachedErrorsMutex sync.RWMutex
+	cachedErrors      = make(map[string]DropboxError)
+)
+
+// CachedNew returns an error for the given key, which is created (as by New)
+// the first time the key is seen, and shared by all later calls.  This bounds
+// the cost of creating the same error repeatedly in a tight loop, e.g. for
+// per-row validation failures.
+//
+// NOTE: The returned error's stack reflects only the first call, msg is
+// ignored once the key has been cached, and all callers share (and thus
+// must not modify) the same error.
+func CachedNew(key string, msg string) DropboxError {
+	cachedErrorsMutex.RLock()
+	err, ok := cachedErrors[key]
+	cachedErrorsMutex.RUnlock()
+	if ok {
+		return err
+	}
+
+	pcs := callers()
+	cachedErrorsMutex.Lock()
+	defer cachedErrorsMutex.Unlock()
+	if err, ok := cachedErrors[key]; ok {
+		return err
+	}
+	err = enrich(&DropboxBaseError{
+		Msg: sanitizeMessage(msg),
+		pcs: pcs,
+	})
+	cachedErrors[key] = err
+	return err
+}
+
+// Same as New, but also sets the error's code (see WithCode).
+func NewWithCode(code, msg string) DropboxError {
+	return enrich(&DropboxBaseError{
+		Msg:  sanitizeMessage(msg),
+		code: code,
+		pcs:  callers(),
+	})
+}
+
 // Same as New, but with fmt.Printf-style parameters.
 func Newf(format string, args ...interface{}) DropboxError {
-	stack, context := StackTrace()
-	return &DropboxBaseError{
-		Msg:     fmt.Sprintf(format, args...),
-		Stack:   stack,
-		Context: context,
-	}
+	return enrich(&DropboxBaseError{
+		Msg: sanitizeMessage(fmt.Sprintf(format, args...)),
+		pcs: callers(),
+	})
 }
 
 // Wraps another error in a new DropboxBaseError.
 func Wrap(err error, msg string) DropboxError {
-	stack, context := StackTrace()
-	return &DropboxBaseError{
-		Msg:     msg,
-		Stack:   stack,
-		Context: context,
-		inner:   err,
+	return newWithOptions(msg, err, nil)
+}
+
+// NewSkip is the same as New, but skips the given number of additional
+// frames from the top of the stack, so that helpers creating errors on
+// behalf of their callers can point the stack at the call site (skip 1 for
+// the helper's caller).
+func NewSkip(skip int, msg string) DropboxError {
+	return newWithOptions(msg, nil, []Option{WithSkip(skip)})
+}
+
+// Same as NewSkip, but wraps err.
+func WrapSkip(err error, skip int, msg string) DropboxError {
+	return newWithOptions(msg, err, []Option{WithSkip(skip)})
+}
+
+// WrapUnlessCode is the same as Wrap, but also sets code on the new error
+// unless an error of err's chain already carries it.  This avoids stamping
+// the same code at every layer, which would hide a more specific code set
+// in between.
+func WrapUnlessCode(err error, code, msg string) DropboxError {
+	for _, e := range walk(err) {
+		if linkCode(e) == code {
+			return newWithOptions(msg, err, nil)
+		}
 	}
+	return newWithOptions(msg, err, []Option{WithCode(code)})
 }
 
 // Same as Wrap, but with fmt.Printf-style parameters.
 func Wrapf(err error, format string, args ...interface{}) DropboxError {
-	stack, context := StackTrace()
+	return enrich(&DropboxBaseError{
+		Msg:   sanitizeMessage(fmt.Sprintf(format, args...)),
+		pcs:   callers(),
+		inner: err,
+	})
+}
+
+// NewWithPCs is the same as New, but uses the program counters pcs (as
+// returned by runtime.Callers) as the error's stack instead of capturing
+// the current one.  This avoids a second capture in instrumentation which
+// already has the program counters.  The stack is symbolized on demand.
+func NewWithPCs(msg string, pcs []uintptr) DropboxError {
+	return enrich(&DropboxBaseError{
+		Msg: sanitizeMessage(msg),
+		pcs: append([]uintptr(nil), pcs...),
+	})
+}
+
+// Same as NewWithPCs, but wraps err.
+func WrapWithPCs(err error, msg string, pcs []uintptr) DropboxError {
+	return enrich(&DropboxBaseError{
+		Msg:   sanitizeMessage(msg),
+		pcs:   append([]uintptr(nil), pcs...),
+		inner: err,
+	})
+}
+
+// Reconstruct returns a DropboxBaseError with the given message, stack and
+// state, e.g. as parsed back from a log line.  Unlike the other constructors,
+// it neither captures the current stack nor runs the enrichers, since the
+// error describes a failure that happened elsewhere.
+func Reconstruct(msg, stack string, state map[string]interface{}) DropboxError {
 	return &DropboxBaseError{
-		Msg:     fmt.Sprintf(format, args...),
-		Stack:   stack,
-		Context: context,
-		inner:   err,
+		Msg:   msg,
+		Stack: stack,
+		State: state,
 	}
 }
 
+// When set, DefaultError reports the concrete type of the chain's root cause.
+var showRootType = false
+
+// SetShowRootType controls whether DefaultError appends a
+// "ROOT CAUSE TYPE: <type>" line naming the concrete type of the inner-most
+// error (e.g. *net.OpError).  This should be called during initialization.
+func SetShowRootType(show bool) {
+	showRootType = show
+}
+
 // A default implementation of the Error method of the error interface.
 func DefaultError(e DropboxError) string {
+	if errorJSONMode {
+		if out, err := json.Marshal(newJSONError(e, 0)); err == nil {
+			return string(out)
+		}
+	}
+
 	// Find the "original" stack trace, which is probably the most helpful for
 	// debugging.
 	errLines := make([]string, 1)
@@ -223,39 +811,87 @@ func DefaultError(e DropboxError) string {
 	errLines = append(errLines, "")
 	errLines = append(errLines, "MEANINGFUL STACK TRACE:")
 	errLines = append(errLines, origStack)
+	if showRootType {
+		errLines = append(
+			errLines,
+			fmt.Sprintf("ROOT CAUSE TYPE: %v", reflect.TypeOf(RootCause(e))))
+	}
 	return strings.Join(errLines, "\n")
 }
 
 // Fills errLines with all error messages, and origStack with the inner-most
 // stack.
 func fillErrorInfo(err error, errLines *[]string, origStack *string) {
-	if err == nil {
-		return
-	}
+	for _, e := range walk(err) {
+		derr, ok := e.(DropboxError)
+		if !ok {
+			*errLines = append(*errLines, linkMessage(e))
+			continue
+		}
 
-	derr, ok := err.(DropboxError)
-	if ok {
-		state, err := json.Marshal(derr.GetState())
+		state, err := json.Marshal(renderedState(derr.GetState()))
 		if err != nil {
 			state = []byte(err.Error())
 		}
-		*errLines = append(*errLines, derr.GetMessage(), string(state))
+		*errLines = append(*errLines, linkMessage(derr), string(state))
 		if dberr, ok := derr.(*DropboxBaseError); !ok || !dberr.Constant || origStack == nil {
 			*origStack = derr.GetStack()
 		}
-		fillErrorInfo(derr.GetInner(), errLines, origStack)
-	} else {
-		*errLines = append(*errLines, err.Error())
 	}
 }
 
+// Returns the inner-most DropboxError of the chain which isn't a constant,
+// i.e. the error whose stack trace DefaultError reports as the meaningful
+// stack trace, or nil if there is none.
+func originalError(err error) DropboxError {
+	var original DropboxError
+	for _, e := range walk(err) {
+		derr, ok := e.(DropboxError)
+		if !ok {
+			continue
+		}
+		if dberr, ok := derr.(*DropboxBaseError); !ok || !dberr.Constant {
+			original = derr
+		}
+	}
+	return original
+}
+
+// Returns the frames of the original stack trace of the error chain (see
+// originalError), innermost first.
+func originalFrames(err error) []StackFrame {
+	original := originalError(err)
+	if original == nil {
+		return nil
+	}
+	return errorFrames(original)
+}
+
+// Initial size of the buffer used to capture stack traces.
+var initialStackBufferSize = 128
+
+// SetInitialStackBufferSize sets the initial size, in bytes, of the buffer
+// used by StackTrace (and thus AddNamedStack and custom error types built on
+// it) to capture stack traces (128 by default).  The buffer is doubled until
+// the stack fits, so deployments with known deep stacks can avoid repeated
+// allocations by starting larger.  The error constructors capture program
+// counters instead, and aren't affected.  This should be called during
+// initialization.
+func SetInitialStackBufferSize(n int) error {
+	if n <= 0 {
+		return Newf("Invalid stack buffer size: %d", n)
+	}
+	initialStackBufferSize = n
+	return nil
+}
+
 // Returns a copy of the error with the stack trace field populated and any
 // other shared initialization; skips 'skip' levels of the stack trace.
 //
 // NOTE: This panics on any error.
 func stackTrace(skip int) (current, context string) {
 	// grow buf until it's large enough to store entire stack trace
-	buf := make([]byte, 128)
+	buf := make([]byte, initialStackBufferSize)
 	for {
 		n := runtime.Stack(buf, false)
 		if n < len(buf) {
@@ -312,6 +948,18 @@ func stackTrace(skip int) (current, context string) {
 	return strippedBuf.String(), string(buf[index:])
 }
 
+// Returns the stack traces of all goroutines, as formatted by runtime.Stack.
+func allStacks() string {
+	buf := make([]byte, 1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
 // This returns the current stack trace string.  NOTE: the stack creation code
 // is excluded from the stack trace.
 func StackTrace() (current, context string) {
@@ -319,7 +967,8 @@ func StackTrace() (current, context string) {
 }
 
 // ContainsError checks whether the given haystack or any inner errors
-// contain any of the needles.
+// (including errors wrapped with the standard library's conventions) contain
+// any of the needles.
 // Passing nil for haystack returns false as a convenience.
 func ContainsError(haystack error, firstNeedle error, otherNeedles ...error) bool {
 	if haystack == nil {
@@ -327,16 +976,71 @@ func ContainsError(haystack error, firstNeedle error, otherNeedles ...error) boo
 	}
 
 	needles := append([]error{firstNeedle}, otherNeedles...)
-	for _, needle := range needles {
-		if haystack == needle {
-			return true
+	for _, err := range walk(haystack) {
+		if !isComparable(err) {
+			continue
+		}
+		for _, needle := range needles {
+			if err == needle {
+				return true
+			}
 		}
 	}
 
-	if dbe, ok := haystack.(DropboxError); ok {
-		return ContainsError(dbe.GetInner(), firstNeedle, otherNeedles...)
+	return false
+}
+
+// IsNil returns true if err is nil, or if err is a nil pointer boxed in a
+// non-nil error interface.
+//
+// The latter is a classic Go gotcha: a function returning a nil
+// *DropboxBaseError through an error return value produces an error which is
+// NOT equal to nil, so a plain "err != nil" check passes.  Prefer returning a
+// literal nil, and use IsNil where that can't be guaranteed.
+func IsNil(err error) bool {
+	if err == nil {
+		return true
 	}
 
+	v := reflect.ValueOf(err)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
 	return false
 }
 
+// Same returns whether a and b are the same error instance.  Boxed nils (see
+// IsNil) are treated as nil first, so a nil *DropboxBaseError is the same as
+// a literal nil.  Otherwise a and b are the same if they are equal with ==
+// (i.e. the same pointer for pointer errors), or if one of them wraps the
+// other directly, i.e. it is the only inner error returned by one level of
+// unwrapping (GetInner, or the standard library's Unwrap).  So an error is
+// the same as Wrap(err, "..."), but not as an error wrapping it twice, nor as
+// a MultiError holding it among others.  Values of non-comparable error
+// types are never the same, rather than panicking as == would.
+//
+// NOTE: Same is not transitive, so it is not an equivalence relation: with
+// w := Wrap(err, "a"), err is the same as w, and w is the same as
+// Wrap(w, "b"), but err is not the same as Wrap(w, "b").
+func Same(a, b error) bool {
+	aNil, bNil := IsNil(a), IsNil(b)
+	if aNil || bNil {
+		return aNil && bNil
+	}
+	return identical(a, b) || identical(unwrapOnce(a), b) || identical(a, unwrapOnce(b))
+}
+
+// Returns whether a and b are equal with ==, without panicking on
+// non-comparable errors.
+func identical(a, b error) bool {
+	return a != nil && b != nil && isComparable(a) && isComparable(b) && a == b
+}
+
+// Returns the error wrapped by err, if it wraps exactly one.
+func unwrapOnce(err error) error {
+	if inners := unwrap(err); len(inners) == 1 {
+		return inners[0]
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestStackTrace(t *testing.T) {
@@ -15,11 +19,11 @@ func TestStackTrace(t *testing.T) {
 		t.Error("error message %s != expected %s", e.Msg, testMsg)
 	}
 
-	if strings.Index(e.Stack, "godropbox/errors/errors.go") != -1 {
+	if strings.Index(e.GetStack(), "godropbox/errors/errors.go") != -1 {
 		t.Error("stack trace generation code should not be in the error stack trace")
 	}
 
-	if strings.Index(e.Stack, "TestStackTrace") == -1 {
+	if strings.Index(e.GetStack(), "TestStackTrace") == -1 {
 		t.Error("stack trace must have test code in it")
 	}
 
@@ -105,6 +109,14 @@ func (e databaseError) GetInner() error                                    { ret
 func (e databaseError) GetAnnotatedStates() []map[string]interface{}       { return nil }
 func (e databaseError) GetState() map[string]interface{}                   { return nil }
 func (e databaseError) SetState(state map[string]interface{}) DropboxError { return nil }
+func (e databaseError) WithField(key string, value interface{}) DropboxError {
+	return nil
+}
+func (e databaseError) WithFields(fields map[string]interface{}) DropboxError {
+	return nil
+}
+func (e databaseError) GetCode() string                   { return "" }
+func (e databaseError) WithCode(code string) DropboxError { return nil }
 
 // ---------------------------------------
 
@@ -128,3 +140,657 @@ func TestCustomError(t *testing.T) {
 		t.Errorf("couldn't find this function in stack trace:\n%s", errorStr)
 	}
 }
+
+func TestEnrichers(t *testing.T) {
+	saved := enrichers
+	defer func() { enrichers = saved }()
+	enrichers = nil
+
+	addState := func(key string, value interface{}) Enricher {
+		return func(e DropboxError) DropboxError {
+			state := e.GetState()
+			if state == nil {
+				state = make(map[string]interface{})
+			}
+			state[key] = value
+			return e.SetState(state)
+		}
+	}
+	RegisterEnricher(addState("hostname", "web1"))
+	RegisterEnricher(addState("service", "api"))
+
+	for _, err := range []DropboxError{
+		New("new"),
+		Newf("newf %d", 1),
+		Wrap(fmt.Errorf("inner"), "wrap"),
+		Wrapf(fmt.Errorf("inner"), "wrapf %d", 1),
+	} {
+		state := err.GetState()
+		if state["hostname"] != "web1" || state["service"] != "api" {
+			t.Errorf("enrichers not applied to %q: %v", err.GetMessage(), state)
+		}
+	}
+}
+
+func TestIsNil(t *testing.T) {
+	if !IsNil(nil) {
+		t.Error("literal nil should be nil")
+	}
+
+	var typedNil *DropboxBaseError
+	var err error = typedNil
+	if err == nil {
+		t.Fatal("boxed typed nil unexpectedly compares equal to nil")
+	}
+	if !IsNil(err) {
+		t.Error("boxed typed nil should be nil")
+	}
+
+	if IsNil(New("real error")) {
+		t.Error("real error should not be nil")
+	}
+	if IsNil(newDatabaseError("db error", 1)) {
+		t.Error("non-pointer error should not be nil")
+	}
+}
+
+func TestNilReceiver(t *testing.T) {
+	var e *DropboxBaseError
+
+	if s := e.Error(); s != "<nil>" {
+		t.Errorf("unexpected Error() on nil receiver: %q", s)
+	}
+	if e.GetMessage() != "" || e.GetStack() != "" || e.GetContext() != "" {
+		t.Error("expected empty strings on nil receiver")
+	}
+	if e.GetInner() != nil {
+		t.Error("expected nil inner on nil receiver")
+	}
+	if e.GetState() != nil {
+		t.Error("expected nil state on nil receiver")
+	}
+	if e.SetState(map[string]interface{}{"k": "v"}) != nil {
+		t.Error("expected nil from SetState on nil receiver")
+	}
+	if e.GetAnnotatedStates() != nil {
+		t.Error("expected nil annotated states on nil receiver")
+	}
+}
+
+func TestNewAllStacks(t *testing.T) {
+	const numGoroutines = 2
+	started := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			started <- struct{}{}
+			<-done
+		}()
+		<-started
+	}
+
+	err := NewAllStacks("deadlock?")
+	if strings.Index(err.GetStack(), "TestNewAllStacks") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", err.GetStack())
+	}
+	// Each goroutine's dump starts with a "goroutine N [status]:" header.
+	n := strings.Count("\n"+err.GetContext(), "\ngoroutine ")
+	if n < numGoroutines+1 {
+		t.Errorf("expected at least %d goroutines in context, found %d:\n%s",
+			numGoroutines+1, n, err.GetContext())
+	}
+}
+
+func TestShowRootType(t *testing.T) {
+	defer SetShowRootType(false)
+
+	err := Wrap(newDatabaseError("lock wait timeout", 1205), "query failed")
+	if strings.Index(err.Error(), "ROOT CAUSE TYPE") != -1 {
+		t.Errorf("root cause type shouldn't be shown by default:\n%s", err.Error())
+	}
+
+	SetShowRootType(true)
+	if s := err.Error(); strings.Index(s, "ROOT CAUSE TYPE: errors.databaseError") == -1 {
+		t.Errorf("couldn't find root cause type in:\n%s", s)
+	}
+
+	err = Wrap(fmt.Errorf("plain"), "outer")
+	if s := err.Error(); strings.Index(s, "ROOT CAUSE TYPE: *errors.errorString") == -1 {
+		t.Errorf("couldn't find root cause type in:\n%s", s)
+	}
+}
+
+func submitNamedStack(e *DropboxBaseError) { e.AddNamedStack("submit") }
+
+func executeNamedStack(e *DropboxBaseError) { e.AddNamedStack("execute") }
+
+func TestNamedStacks(t *testing.T) {
+	e := New("async failure").(*DropboxBaseError)
+	if len(e.GetNamedStacks()) != 0 {
+		t.Error("expected no named stacks on a new error")
+	}
+
+	submitNamedStack(e)
+	executeNamedStack(e)
+
+	stacks := e.GetNamedStacks()
+	if len(stacks) != 2 {
+		t.Fatalf("expected 2 named stacks, got %v", stacks)
+	}
+	if strings.Index(stacks["submit"], "submitNamedStack") == -1 {
+		t.Errorf("unexpected submit stack:\n%s", stacks["submit"])
+	}
+	if strings.Index(stacks["execute"], "executeNamedStack") == -1 {
+		t.Errorf("unexpected execute stack:\n%s", stacks["execute"])
+	}
+	if strings.Index(stacks["submit"], "AddNamedStack") != -1 {
+		t.Errorf("stack capture code should not be in the stack:\n%s", stacks["submit"])
+	}
+}
+
+// Meant to be run with -race.
+func TestConcurrentNamedStacks(t *testing.T) {
+	e := New("async failure").(*DropboxBaseError)
+	e.AddNamedStack("submit")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				e.AddNamedStack(fmt.Sprintf("worker %d", i))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				e.GetNamedStacks()
+				e.copy().AddNamedStack("copy")
+				e.DeepClone()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stacks := e.GetNamedStacks(); len(stacks) != 5 {
+		t.Errorf("expected 5 named stacks, got %d", len(stacks))
+	}
+	if _, ok := e.GetNamedStacks()["copy"]; ok {
+		t.Error("adding a named stack to a copy should not change the original")
+	}
+}
+
+func TestSetInitialStackBufferSize(t *testing.T) {
+	defer SetInitialStackBufferSize(initialStackBufferSize)
+
+	if SetInitialStackBufferSize(0) == nil || SetInitialStackBufferSize(-1) == nil {
+		t.Error("non-positive buffer sizes should be rejected")
+	}
+	if err := SetInitialStackBufferSize(16); err != nil {
+		t.Fatal(err)
+	}
+	if e := New("small buffer"); strings.Index(e.GetStack(), "TestSetInitialStackBufferSize") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", e.GetStack())
+	}
+}
+
+func benchmarkStackTraceWithBufferSize(b *testing.B, size int) {
+	defer SetInitialStackBufferSize(initialStackBufferSize)
+	SetInitialStackBufferSize(size)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		StackTrace()
+	}
+}
+
+func BenchmarkStackTraceDefaultBuffer(b *testing.B) {
+	benchmarkStackTraceWithBufferSize(b, 128)
+}
+
+func BenchmarkStackTraceTunedBuffer(b *testing.B) {
+	benchmarkStackTraceWithBufferSize(b, 4096)
+}
+
+func TestNewSentinel(t *testing.T) {
+	errClosed := NewSentinel("closed")
+	if errClosed.GetMessage() != "closed" || errClosed.GetStack() != "" || errClosed.GetContext() != "" {
+		t.Errorf("unexpected sentinel %q with stack %q", errClosed.GetMessage(), errClosed.GetStack())
+	}
+
+	wrapped := Wrap(errClosed, "write failed")
+	if !ContainsError(wrapped, errClosed) {
+		t.Error("expected the sentinel in the chain")
+	}
+	if !strings.Contains(wrapped.Error(), "TestNewSentinel") {
+		t.Errorf("expected the wrapper's stack as the meaningful stack:\n%s", wrapped.Error())
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New("benchmark")
+	}
+}
+
+func BenchmarkNewSentinel(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewSentinel("benchmark")
+	}
+}
+
+// Wraps errors on behalf of its caller.
+func wrapQuery(err error) DropboxError {
+	return WrapSkip(err, 1, "query failed")
+}
+
+func TestWrapSkip(t *testing.T) {
+	err := wrapQuery(fmt.Errorf("timeout")).(*DropboxBaseError)
+	frames := err.Frames()
+	if len(frames) == 0 || !strings.HasSuffix(frames[0].Function, "errors.TestWrapSkip") {
+		t.Errorf("expected the helper's caller as the top frame, got %v", frames)
+	}
+
+	frames = NewSkip(0, "direct").(*DropboxBaseError).Frames()
+	if len(frames) == 0 || !strings.HasSuffix(frames[0].Function, "errors.TestWrapSkip") {
+		t.Errorf("expected the caller as the top frame without skip, got %v", frames)
+	}
+}
+
+func TestGetMessageSep(t *testing.T) {
+	err := Wrap(fmt.Errorf("db query failed: %w", fmt.Errorf("connection refused")), "handler failed")
+
+	for sep, expected := range map[string]string{
+		" ":    "handler failed db query failed connection refused",
+		": ":   "handler failed: db query failed: connection refused",
+		" -> ": "handler failed -> db query failed -> connection refused",
+	} {
+		if msg := GetMessageSep(err, sep); msg != expected {
+			t.Errorf("unexpected message %q with separator %q, expected %q", msg, sep, expected)
+		}
+	}
+	if msg := GetMessage(err); msg != GetMessageSep(err, " ") {
+		t.Errorf("GetMessage should join with a space, got %q", msg)
+	}
+}
+
+func TestGetMessages(t *testing.T) {
+	err := Wrap(fmt.Errorf("db query failed: %w", New("connection refused")), "handler failed")
+	expected := []string{"handler failed", "db query failed", "connection refused"}
+	if msgs := GetMessages(err); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("unexpected messages %q, expected %q", msgs, expected)
+	}
+
+	if msgs := GetMessages(fmt.Errorf("plain")); !reflect.DeepEqual(msgs, []string{"plain"}) {
+		t.Errorf("unexpected messages for a plain error %q", msgs)
+	}
+	if GetMessages(nil) != nil {
+		t.Error("expected no messages for nil")
+	}
+}
+
+func TestAppendMessage(t *testing.T) {
+	err := Wrap(fmt.Errorf("timeout"), "query failed").(*DropboxBaseError)
+	depth := len(walk(err))
+
+	err.AppendMessage("table users").(*DropboxBaseError).AppendMessage("attempt 3")
+	if msg := err.GetMessage(); msg != "query failed: table users: attempt 3" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if msg := GetMessage(err); msg != "query failed: table users: attempt 3 timeout" {
+		t.Errorf("unexpected chain message %q", msg)
+	}
+	if d := len(walk(err)); d != depth {
+		t.Errorf("expected the chain depth to stay %d, got %d", depth, d)
+	}
+}
+
+type sliceError []string
+
+func (e sliceError) Error() string { return strings.Join(e, ", ") }
+
+func TestSame(t *testing.T) {
+	var typedNil *DropboxBaseError
+	var boxed error = typedNil
+	if !Same(boxed, nil) || !Same(nil, boxed) || !Same(nil, nil) {
+		t.Error("a boxed nil should be the same as nil")
+	}
+
+	err := New("boom")
+	alias := error(err)
+	if !Same(err, alias) {
+		t.Error("identical pointers should be the same")
+	}
+	if Same(err, New("boom")) || Same(err, nil) {
+		t.Error("different instances should not be the same")
+	}
+	if !Same(err, Wrap(err, "")) || !Same(fmt.Errorf("ctx: %w", err), err) {
+		t.Error("an error should be the same as its direct wrapper")
+	}
+	if Same(err, Wrap(Wrap(err, ""), "")) || Same(Wrap(err, "a"), Wrap(err, "b")) ||
+		Same(err, Append(nil, err, New("other"))) {
+		t.Error("errors wrapped more than once, or wrapping the same error, should not be the same")
+	}
+	if Same(sliceError{"a"}, sliceError{"a"}) {
+		t.Error("non-comparable errors should not be the same")
+	}
+}
+
+func TestSanitizeMessages(t *testing.T) {
+	defer SetSanitizeMessages(false)
+	const invalid = "bad \xff\xfe bytes"
+
+	if e := New(invalid); e.GetMessage() != invalid {
+		t.Error("messages shouldn't be sanitized by default")
+	}
+
+	SetSanitizeMessages(true)
+	for _, e := range []DropboxError{
+		New(invalid),
+		Newf("%s", invalid),
+		Wrap(nil, invalid),
+		Wrapf(nil, "%s", invalid),
+	} {
+		if msg := e.GetMessage(); !utf8.ValidString(msg) || msg != "bad � bytes" {
+			t.Errorf("message not sanitized: %q", msg)
+		}
+	}
+	if e := New("valid ☃"); e.GetMessage() != "valid ☃" {
+		t.Errorf("valid message modified: %q", e.GetMessage())
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	const stack = "goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x25\n"
+	state := map[string]interface{}{"user_id": 42}
+
+	e := Reconstruct("replayed", stack, state)
+	if e.GetMessage() != "replayed" || e.GetStack() != stack || e.GetContext() != "" {
+		t.Errorf("unexpected reconstructed error:\n%s", e.Error())
+	}
+	if e.GetState()["user_id"] != 42 || e.GetInner() != nil {
+		t.Errorf("unexpected reconstructed state %v", e.GetState())
+	}
+	if strings.Index(e.Error(), "/src/main.go:10") == -1 {
+		t.Errorf("couldn't find the provided stack in:\n%s", e.Error())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	inner := Wrap(fmt.Errorf("connection refused"), "query failed")
+	e := Wrap(inner, "handler failed").SetState(map[string]interface{}{"user_id": 42})
+
+	snapshot := e.(*DropboxBaseError).Snapshot()
+	if snapshot.Message != "handler failed" || snapshot.InnerMessage != "query failed" {
+		t.Errorf("unexpected snapshot messages %+v", snapshot)
+	}
+	if snapshot.Code != "" {
+		t.Errorf("unexpected snapshot code %q", snapshot.Code)
+	}
+	if code := e.WithCode("UNAVAILABLE").(*DropboxBaseError).Snapshot().Code; code != "UNAVAILABLE" {
+		t.Errorf("unexpected snapshot code %q", code)
+	}
+	if snapshot.State["user_id"] != 42 {
+		t.Errorf("unexpected snapshot state %v", snapshot.State)
+	}
+	if strings.Index(snapshot.TopFrame.Function, "TestSnapshot") == -1 ||
+		!strings.HasSuffix(snapshot.TopFrame.File, "errors_test.go") {
+		t.Errorf("unexpected snapshot top frame %+v", snapshot.TopFrame)
+	}
+
+	snapshot.State["user_id"] = 7
+	if e.GetState()["user_id"] != 42 {
+		t.Error("mutating the snapshot state changed the error")
+	}
+}
+
+func TestGetAnnotatedStatesCycle(t *testing.T) {
+	first := New("first").(*DropboxBaseError)
+	second := Wrap(first, "second")
+	first.inner = second
+
+	states := first.GetAnnotatedStates()
+	if len(states) != 2 {
+		t.Fatalf("expected each error exactly once, got %v", states)
+	}
+	if states[0]["_message"] != "first" || states[1]["_message"] != "second" {
+		t.Errorf("unexpected annotated states %v", states)
+	}
+}
+
+func TestGetAnnotatedStatesLocation(t *testing.T) {
+	short := Reconstruct("short", "goroutine 1 [running]:\n"+
+		"main.main()\n"+
+		"\t/src/main.go:5 +0x1d\n", nil)
+	long := Wrap(Reconstruct("long", testStack, nil), "live")
+	states := Wrap(short, "outer").(*DropboxBaseError).GetAnnotatedStates()
+
+	if loc := states[1]["_location"]; loc != "main.main /src/main.go:5" {
+		t.Errorf("unexpected location for a short stack %q", loc)
+	}
+
+	states = long.(*DropboxBaseError).GetAnnotatedStates()
+	if loc, _ := states[0]["_location"].(string); !strings.Contains(loc, "errors.TestGetAnnotatedStatesLocation ") {
+		t.Errorf("unexpected location for a live stack %q", loc)
+	}
+	if loc := states[1]["_location"]; loc != "github.com/saleswise/app/db.Query /src/app/db/query.go:12" {
+		t.Errorf("unexpected location for a long stack %q", loc)
+	}
+
+	if loc := Reconstruct("none", "", nil).GetAnnotatedStates()[0]["_location"]; loc != "" {
+		t.Errorf("expected an empty location without stack, got %q", loc)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	sentinel := fmt.Errorf("sentinel")
+	wrapped := Wrap(Wrap(sentinel, "ctx"), "outer")
+
+	if !stderrors.Is(wrapped, sentinel) {
+		t.Error("errors.Is should find the sentinel in the chain")
+	}
+	if stderrors.Unwrap(wrapped) != wrapped.GetInner() {
+		t.Error("errors.Unwrap should return the inner error")
+	}
+	if inner := New("leaf").(*DropboxBaseError).Unwrap(); inner != nil {
+		t.Errorf("expected a nil interface without an inner error, got %#v", inner)
+	}
+}
+
+var errNotFound = NewConstant("not found")
+
+func TestIs(t *testing.T) {
+	wrapped := Wrap(fmt.Errorf("lookup: %w", Wrap(errNotFound, "query failed")), "handler failed")
+
+	if !wrapped.(*DropboxBaseError).Is(errNotFound) {
+		t.Error("Is should find the sentinel in the chain")
+	}
+	if !stderrors.Is(wrapped, errNotFound) {
+		t.Error("errors.Is should find the sentinel in the chain")
+	}
+	if stderrors.Is(wrapped, NewConstant("not found")) {
+		t.Error("errors.Is should not match a different error with the same message")
+	}
+	if stderrors.Is(Wrap(fmt.Errorf("other"), "handler failed"), errNotFound) {
+		t.Error("errors.Is should not match an error without the sentinel")
+	}
+}
+
+func TestAs(t *testing.T) {
+	dbErr := newDatabaseError("lock wait timeout", 1205)
+	wrapped := Wrap(fmt.Errorf("query: %w", dbErr), "handler failed")
+
+	var target databaseError
+	if !wrapped.(*DropboxBaseError).As(&target) || target.Code != 1205 {
+		t.Errorf("As should find the database error, got %+v", target)
+	}
+
+	target = databaseError{}
+	if !stderrors.As(wrapped, &target) || target.Code != 1205 {
+		t.Errorf("errors.As should find the database error, got %+v", target)
+	}
+
+	var base *DropboxBaseError
+	if !wrapped.(*DropboxBaseError).As(&base) || base != wrapped {
+		t.Error("As should match the receiver itself")
+	}
+
+	var missing databaseError
+	if Wrap(fmt.Errorf("other"), "x").(*DropboxBaseError).As(&missing) {
+		t.Error("As should not match a chain without a database error")
+	}
+
+	for _, target := range []interface{}{nil, target, (*databaseError)(nil), new(int)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("As should panic for target %#v", target)
+				}
+			}()
+			wrapped.(*DropboxBaseError).As(target)
+		}()
+	}
+}
+
+func TestCachedNew(t *testing.T) {
+	first := CachedNew("TestCachedNew", "invalid row")
+	if strings.Index(first.GetStack(), "TestCachedNew") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", first.GetStack())
+	}
+
+	if CachedNew("TestCachedNew", "ignored") != first {
+		t.Error("repeated calls with the same key should return the cached error")
+	}
+	if CachedNew("TestCachedNew/other", "invalid row") == first {
+		t.Error("calls with different keys should return different errors")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() { CachedNew("TestCachedNew", "invalid row") })
+	if allocs != 0 {
+		t.Errorf("expected cached calls not to allocate, got %v allocations", allocs)
+	}
+}
+
+func TestWithState(t *testing.T) {
+	original := New("boom").SetState(map[string]interface{}{"a": 1, "b": 1})
+
+	updated := original.(*DropboxBaseError).WithState(map[string]interface{}{"b": 2, "c": 3})
+	state := updated.GetState()
+	if len(state) != 3 || state["a"] != 1 || state["b"] != 2 || state["c"] != 3 {
+		t.Errorf("unexpected merged state %v", state)
+	}
+	if updated.GetMessage() != "boom" || updated.GetStack() != original.GetStack() {
+		t.Error("WithState should preserve the message and stack")
+	}
+
+	state = original.GetState()
+	if len(state) != 2 || state["b"] != 1 {
+		t.Errorf("WithState should not modify the original, got %v", state)
+	}
+}
+
+func TestWithField(t *testing.T) {
+	err := New("boom").WithField("user_id", 42).WithField("attempt", 3)
+	state := err.GetState()
+	if len(state) != 2 || state["user_id"] != 42 || state["attempt"] != 3 {
+		t.Errorf("unexpected state %v", state)
+	}
+
+	err = Wrap(err, "outer").WithFields(map[string]interface{}{"a": 1, "b": 2}).WithField("b", 3)
+	state = err.GetState()
+	if len(state) != 2 || state["a"] != 1 || state["b"] != 3 {
+		t.Errorf("unexpected state %v", state)
+	}
+
+	var typedNil *DropboxBaseError
+	if typedNil.WithField("k", "v") != nil || typedNil.WithFields(nil) != nil {
+		t.Error("expected nil on nil receiver")
+	}
+}
+
+func TestCodes(t *testing.T) {
+	inner := NewWithCode("RATE_LIMITED", "too many requests")
+	if c := inner.GetCode(); c != "RATE_LIMITED" {
+		t.Errorf("unexpected code %q", c)
+	}
+	if strings.Index(inner.GetStack(), "TestCodes") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", inner.GetStack())
+	}
+
+	wrapped := Wrap(fmt.Errorf("retry: %w", inner), "fetch failed")
+	if c := wrapped.GetCode(); c != "RATE_LIMITED" {
+		t.Errorf("expected the inner code to be preserved, got %q", c)
+	}
+	if c := GetCode(wrapped); c != "RATE_LIMITED" {
+		t.Errorf("unexpected code %q", c)
+	}
+
+	overridden := Wrap(inner, "quota exceeded").WithCode("QUOTA_EXCEEDED")
+	if c := GetCode(overridden); c != "QUOTA_EXCEEDED" {
+		t.Errorf("expected the outer code to win, got %q", c)
+	}
+	if c := GetCode(inner); c != "RATE_LIMITED" {
+		t.Errorf("overriding should not modify the inner code, got %q", c)
+	}
+
+	if c := GetCode(New("no code")); c != "" {
+		t.Errorf("expected no code, got %q", c)
+	}
+	if c := GetCode(fmt.Errorf("plain")); c != "" {
+		t.Errorf("expected no code for a plain error, got %q", c)
+	}
+	if c := GetCode(nil); c != "" {
+		t.Errorf("expected no code for nil, got %q", c)
+	}
+}
+
+func TestMostSevereCode(t *testing.T) {
+	severity := func(code string) int {
+		return map[string]int{"WARN": 1, "ERROR": 2, "FATAL": 3}[code]
+	}
+
+	err := Wrap(Wrap(NewWithCode("WARN", "slow disk"), "write failed").WithCode("FATAL"), "sync failed").
+		WithCode("ERROR")
+	if c := MostSevereCode(err, severity); c != "FATAL" {
+		t.Errorf("expected the most severe code, got %q", c)
+	}
+	if c := GetCode(err); c != "ERROR" {
+		t.Errorf("expected the outermost code from GetCode, got %q", c)
+	}
+
+	unranked := Wrap(NewWithCode("WARN", "slow disk"), "retrying").WithCode("UNKNOWN")
+	if c := MostSevereCode(unranked, severity); c != "WARN" {
+		t.Errorf("expected the more severe code over an unranked one, got %q", c)
+	}
+	if c := MostSevereCode(Wrap(NewWithCode("A", "a"), "b").WithCode("B"), severity); c != "B" {
+		t.Errorf("expected ties to go to the outermost code, got %q", c)
+	}
+	if c := MostSevereCode(New("no code"), severity); c != "" {
+		t.Errorf("expected no code, got %q", c)
+	}
+}
+
+func TestWrapUnlessCode(t *testing.T) {
+	inner := NewWithCode("UNAVAILABLE", "connection refused")
+	specific := Wrap(inner, "replica lagging").WithCode("STALE_READ")
+
+	err := WrapUnlessCode(specific, "UNAVAILABLE", "query failed")
+	if c := GetCode(err); c != "STALE_READ" {
+		t.Errorf("expected the more specific code to be kept, got %q", c)
+	}
+	if err.GetMessage() != "query failed" || err.GetInner() != specific {
+		t.Errorf("expected the error to be wrapped, got %q", GetMessage(err))
+	}
+	if strings.Index(err.GetStack(), "TestWrapUnlessCode") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", err.GetStack())
+	}
+
+	err = WrapUnlessCode(fmt.Errorf("timeout"), "UNAVAILABLE", "query failed")
+	if c := GetCode(err); c != "UNAVAILABLE" {
+		t.Errorf("expected the code to be set, got %q", c)
+	}
+}
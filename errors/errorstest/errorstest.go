@@ -0,0 +1,60 @@
+// Package errorstest provides helpers for tests dealing with errors, kept
+// apart so that the errors package itself doesn't depend on testing.
+package errorstest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/saleswise/errors/errors"
+)
+
+// FatalIf fails the test if err is non-nil, reporting err's verbose
+// rendering (see errors.Verbose) so that the stack trace shows up in the test
+// output.
+func FatalIf(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", errors.Verbose(err))
+	}
+}
+
+// AssertChain fails the test unless the levels of err's chain (see
+// errors.Links) have exactly the expected messages and codes, outermost
+// first.  This is less brittle than matching on err.Error().  Each mismatch
+// is reported, along with the whole chain.
+func AssertChain(t testing.TB, err error, expected []struct{ Msg, Code string }) {
+	t.Helper()
+	links := errors.Links(err)
+	failed := len(links) != len(expected)
+	if failed {
+		t.Errorf("expected %d errors in the chain, got %d", len(expected), len(links))
+	}
+	for i := 0; i < len(links) && i < len(expected); i++ {
+		if links[i].Msg != expected[i].Msg {
+			t.Errorf("level %d: expected message %q, got %q", i, expected[i].Msg, links[i].Msg)
+			failed = true
+		}
+		if links[i].Code != expected[i].Code {
+			t.Errorf("level %d: expected code %q, got %q", i, expected[i].Code, links[i].Code)
+			failed = true
+		}
+	}
+	if failed {
+		t.Errorf("actual chain:\n%s", formatLinks(links))
+	}
+}
+
+// Renders links one per line, e.g. "1: [UNAVAILABLE] connection refused".
+func formatLinks(links []errors.Link) string {
+	lines := make([]string, 0, len(links))
+	for i, link := range links {
+		if link.Code != "" {
+			lines = append(lines, fmt.Sprintf("%d: [%s] %s", i, link.Code, link.Msg))
+		} else {
+			lines = append(lines, fmt.Sprintf("%d: %s", i, link.Msg))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,87 @@
+package errorstest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/saleswise/errors/errors"
+)
+
+// A testing.TB which records failures instead of failing the test.
+type fakeTB struct {
+	testing.TB
+
+	helper bool
+	failed bool
+	output string
+	reports []string
+}
+
+func (t *fakeTB) Helper() { t.helper = true }
+
+func (t *fakeTB) Errorf(format string, args ...interface{}) {
+	t.failed = true
+	t.reports = append(t.reports, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeTB) Fatalf(format string, args ...interface{}) {
+	t.failed = true
+	t.output = fmt.Sprintf(format, args...)
+}
+
+func TestFatalIf(t *testing.T) {
+	tb := &fakeTB{}
+	FatalIf(tb, nil)
+	if tb.failed {
+		t.Error("FatalIf should not fail on a nil error")
+	}
+
+	tb = &fakeTB{}
+	FatalIf(tb, errors.New("boom"))
+	if !tb.failed || !tb.helper {
+		t.Error("FatalIf should fail as a test helper on a non-nil error")
+	}
+	if strings.Index(tb.output, "boom") == -1 ||
+		strings.Index(tb.output, "MEANINGFUL STACK TRACE") == -1 {
+		t.Errorf("FatalIf should report the verbose error, got:\n%s", tb.output)
+	}
+}
+
+func TestAssertChain(t *testing.T) {
+	err := errors.Wrap(errors.NewWithCode("UNAVAILABLE", "connection refused"), "query failed")
+
+	tb := &fakeTB{}
+	AssertChain(tb, err, []struct{ Msg, Code string }{
+		{"query failed", ""},
+		{"connection refused", "UNAVAILABLE"},
+	})
+	if tb.failed {
+		t.Errorf("AssertChain should pass on a matching chain, got %v", tb.reports)
+	}
+
+	tb = &fakeTB{}
+	AssertChain(tb, err, []struct{ Msg, Code string }{
+		{"query failed", "UNAVAILABLE"},
+		{"timeout", "UNAVAILABLE"},
+	})
+	if !tb.failed || !tb.helper {
+		t.Fatal("AssertChain should fail as a test helper on a mismatching chain")
+	}
+	report := strings.Join(tb.reports, "\n")
+	for _, expected := range []string{
+		`level 0: expected code "UNAVAILABLE", got ""`,
+		`level 1: expected message "timeout", got "connection refused"`,
+		"1: [UNAVAILABLE] connection refused",
+	} {
+		if strings.Index(report, expected) == -1 {
+			t.Errorf("couldn't find %q in:\n%s", expected, report)
+		}
+	}
+
+	tb = &fakeTB{}
+	AssertChain(tb, err, []struct{ Msg, Code string }{{"query failed", ""}})
+	if !tb.failed || strings.Index(tb.reports[0], "expected 1 errors in the chain, got 2") == -1 {
+		t.Errorf("AssertChain should report a length mismatch, got %v", tb.reports)
+	}
+}
@@ -0,0 +1,86 @@
+package errors
+
+import (
+	"fmt"
+	"io"
+)
+
+// Maximum length, in runes, of the strings returned by Title.
+var titleMaxLength = 80
+
+// SetTitleMaxLength sets the maximum length, in runes, of the strings
+// returned by Title.  Non-positive values disable truncation.  This should
+// be called during initialization.
+func SetTitleMaxLength(n int) {
+	titleMaxLength = n
+}
+
+// Title returns a terse one-line summary of err, suitable for alert titles:
+// the outermost message, prefixed with err's code if any (see GetCode), e.g.
+// "[DB_TIMEOUT] query failed", and truncated to the configured maximum length
+// (see SetTitleMaxLength).  Returns "" for a nil error.
+func Title(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var title string
+	if dbe, ok := err.(DropboxError); ok {
+		title = dbe.GetMessage()
+	} else {
+		title = err.Error()
+	}
+	if code := GetCode(err); code != "" {
+		title = "[" + code + "] " + title
+	}
+	return truncate(title, titleMaxLength)
+}
+
+// Truncates s to at most n runes, marking truncation with an ellipsis.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(runes[:n])
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// Verbose returns the DefaultError rendering of err (messages and stack
+// trace) if it is a DropboxError, or err.Error() otherwise.  Returns "" for a
+// nil error.
+func Verbose(err error) string {
+	if err == nil {
+		return ""
+	}
+	if dbe, ok := err.(DropboxError); ok {
+		return DefaultError(dbe)
+	}
+	return err.Error()
+}
+
+// Format implements fmt.Formatter, following the pkg/errors conventions:
+// %s and %v print the messages of the chain without the stack trace, %+v
+// prints the full Error() rendering (messages, state and stack trace), and
+// %q prints the messages of the chain quoted.
+func (e *DropboxBaseError) Format(s fmt.State, verb rune) {
+	if e == nil {
+		io.WriteString(s, "<nil>")
+		return
+	}
+	e.symbolize()
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			io.WriteString(s, e.Error())
+			return
+		}
+		io.WriteString(s, GetMessage(e))
+	case 's':
+		io.WriteString(s, GetMessage(e))
+	case 'q':
+		fmt.Fprintf(s, "%q", GetMessage(e))
+	}
+}
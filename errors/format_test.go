@@ -0,0 +1,80 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTitle(t *testing.T) {
+	defer SetTitleMaxLength(titleMaxLength)
+
+	err := Wrap(fmt.Errorf("connection refused"), "query failed")
+	if title := Title(err); title != "query failed" {
+		t.Errorf("unexpected title %q", title)
+	}
+	if title := Title(fmt.Errorf("plain")); title != "plain" {
+		t.Errorf("unexpected title for plain error %q", title)
+	}
+	if Title(nil) != "" {
+		t.Error("expected empty title for nil error")
+	}
+	coded := Wrap(NewWithCode("DB_TIMEOUT", "timeout"), "query failed")
+	if title := Title(coded); title != "[DB_TIMEOUT] query failed" {
+		t.Errorf("unexpected title with code %q", title)
+	}
+
+	SetTitleMaxLength(8)
+	if title := Title(err); title != "query..." {
+		t.Errorf("unexpected truncated title %q", title)
+	}
+	SetTitleMaxLength(0)
+	if title := Title(err); title != "query failed" {
+		t.Errorf("unexpected untruncated title %q", title)
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	err := Wrap(fmt.Errorf("connection refused"), "query failed")
+	if s := Verbose(err); s != DefaultError(err) {
+		t.Errorf("unexpected verbose rendering:\n%s", s)
+	}
+	if s := Verbose(fmt.Errorf("plain")); s != "plain" {
+		t.Errorf("unexpected verbose rendering of plain error: %q", s)
+	}
+	if Verbose(nil) != "" {
+		t.Error("expected empty verbose rendering for nil error")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	err := Wrap(New("connection refused"), "query failed")
+
+	for _, verb := range []string{"%s", "%v"} {
+		if s := fmt.Sprintf(verb, err); s != "query failed connection refused" {
+			t.Errorf("unexpected %s rendering %q", verb, s)
+		}
+	}
+	if s := fmt.Sprintf("%q", err); s != `"query failed connection refused"` {
+		t.Errorf("unexpected %%q rendering %s", s)
+	}
+	if s := fmt.Sprintf("%+v", err); s != err.Error() ||
+		!strings.Contains(s, "TestFormat") {
+		t.Errorf("expected %%+v to include the stack trace, got:\n%s", s)
+	}
+
+	var nilErr *DropboxBaseError
+	if s := fmt.Sprintf("%v", nilErr); s != "<nil>" {
+		t.Errorf("unexpected rendering of a nil error %q", s)
+	}
+
+	// %w embeds the message chain, which is still stripped from the
+	// wrapper's own message.
+	wrapped := fmt.Errorf("handler: %w", err)
+	if s := wrapped.Error(); s != "handler: query failed connection refused" {
+		t.Errorf("unexpected %%w rendering %q", s)
+	}
+	if msg := GetMessage(wrapped); msg != "handler query failed connection refused" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
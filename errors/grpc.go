@@ -0,0 +1,43 @@
+//go:build grpc
+
+// gRPC interop lives behind the "grpc" build tag, so that the core package
+// doesn't depend on google.golang.org/grpc.  Build with -tags grpc to use it.
+
+package errors
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// This attaches the gRPC code which should be returned for the error, and
+// returns the error for chaining.  See GRPCStatus.
+func (e *DropboxBaseError) WithGRPCCode(c codes.Code) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	e.grpcCode = uint32(c)
+	e.grpcCodeSet = true
+	return e
+}
+
+// GRPCStatus builds a gRPC status out of the first (i.e. outermost) code
+// attached to err's chain with WithGRPCCode, or codes.Unknown if there is
+// none, and the messages of the chain.  Returns nil for a nil error.
+func GRPCStatus(err error) *status.Status {
+	if err == nil {
+		return nil
+	}
+	code := codes.Unknown
+	for _, e := range walk(err) {
+		if dbe, ok := e.(*DropboxBaseError); ok {
+			if c, ok := dbe.ownGRPCCode(); ok {
+				code = codes.Code(c)
+				break
+			}
+		}
+	}
+	return status.New(code, GetMessage(err))
+}
@@ -0,0 +1,31 @@
+//go:build grpc
+
+package errors
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestGRPCStatus(t *testing.T) {
+	notFound := New("no such user").(*DropboxBaseError).WithGRPCCode(codes.NotFound)
+	st := GRPCStatus(Wrap(notFound, "get profile"))
+	if st.Code() != codes.NotFound || st.Message() != "get profile no such user" {
+		t.Errorf("unexpected status %v", st)
+	}
+
+	if st := GRPCStatus(Wrap(notFound, "denied").(*DropboxBaseError).WithGRPCCode(codes.PermissionDenied)); st.Code() != codes.PermissionDenied {
+		t.Errorf("expected the outermost code, got %v", st.Code())
+	}
+	if st := GRPCStatus(New("boom")); st.Code() != codes.Unknown {
+		t.Errorf("expected codes.Unknown without a code, got %v", st.Code())
+	}
+	if st := GRPCStatus(fmt.Errorf("plain")); st.Code() != codes.Unknown || st.Message() != "plain" {
+		t.Errorf("unexpected status for a plain error %v", st)
+	}
+	if GRPCStatus(nil) != nil {
+		t.Error("expected no status for nil")
+	}
+}
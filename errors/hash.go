@@ -0,0 +1,29 @@
+package errors
+
+import (
+	"hash/fnv"
+)
+
+// Hash returns a stable hash of err, suitable for deduplicating or caching by
+// error identity.  The hash covers the message, the code and the top stack
+// frame of every error in the chain; state and full stacks are excluded
+// since they are too volatile.  Two errors with the same messages and codes
+// created at the same call sites hash equal.  Returns 0 for a nil error.
+func Hash(err error) uint64 {
+	if err == nil {
+		return 0
+	}
+
+	h := fnv.New64a()
+	for _, e := range walk(err) {
+		h.Write([]byte(linkMessage(e)))
+		h.Write([]byte{0})
+		h.Write([]byte(linkCode(e)))
+		h.Write([]byte{0})
+		if dbe, ok := e.(DropboxError); ok {
+			h.Write([]byte(topFrame(errorFrames(dbe))))
+		}
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
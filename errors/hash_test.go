@@ -0,0 +1,50 @@
+package errors
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	// Errors built by the same call site have the same top frames.
+	build := func(msg string, state map[string]interface{}) DropboxError {
+		return Wrap(fmt.Errorf("inner"), msg).SetState(state)
+	}
+	a := build("outer", map[string]interface{}{"attempt": 1})
+	b := build("outer", map[string]interface{}{"attempt": 2})
+	if Hash(a) != Hash(b) {
+		t.Error("structurally identical errors should hash equal")
+	}
+
+	if Hash(a) == Hash(build("other", nil)) {
+		t.Error("errors with different messages should hash differently")
+	}
+
+	if Hash(a) == Hash(Wrap(fmt.Errorf("inner"), "outer")) {
+		t.Error("errors created at different call sites should hash differently")
+	}
+
+	if Hash(a) == Hash(build("outer", nil).WithCode("UNAVAILABLE")) {
+		t.Error("errors with different codes should hash differently")
+	}
+
+	if Hash(nil) != 0 {
+		t.Error("nil error should hash to 0")
+	}
+}
+
+func TestTopFrame(t *testing.T) {
+	stack := "goroutine 1 [running]:\n" +
+		"github.com/saleswise/app.(*Server).Handle(0xc000010000, 0x1)\n" +
+		"\t/src/app/server.go:42 +0x1d\n" +
+		"main.main()\n" +
+		"\t/src/app/main.go:10 +0x25"
+	expected := "github.com/saleswise/app.(*Server).Handle /src/app/server.go:42"
+	if frame := topFrame(parseStack(stack)); frame != expected {
+		t.Errorf("unexpected top frame %q, expected %q", frame, expected)
+	}
+
+	if frame := topFrame(nil); frame != "" {
+		t.Errorf("expected empty top frame without frames, got %q", frame)
+	}
+}
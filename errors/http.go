@@ -0,0 +1,43 @@
+package errors
+
+import (
+	"net/http"
+)
+
+// This attaches the HTTP status code (e.g. http.StatusNotFound) which should
+// be returned for the error, so that the mapping is decided where the error
+// is created rather than in handler code, and returns the error for
+// chaining.  See HTTPStatus.
+func (e *DropboxBaseError) WithHTTPStatus(code int) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	e.httpStatus = code
+	return e
+}
+
+// Returns the HTTP status code set on e itself, ignoring inner errors.
+func (e *DropboxBaseError) ownHTTPStatus() int {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	return e.httpStatus
+}
+
+// HTTPStatus returns the first (i.e. outermost) HTTP status code attached to
+// err's chain with WithHTTPStatus, or http.StatusInternalServerError if
+// there is none.  Returns 0 for a nil error.
+func HTTPStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	for _, e := range walk(err) {
+		if dbe, ok := e.(*DropboxBaseError); ok {
+			if code := dbe.ownHTTPStatus(); code != 0 {
+				return code
+			}
+		}
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,32 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	notFound := New("no such user").(*DropboxBaseError).WithHTTPStatus(http.StatusNotFound)
+	if code := HTTPStatus(notFound); code != http.StatusNotFound {
+		t.Errorf("unexpected status %d", code)
+	}
+	if code := HTTPStatus(Wrap(fmt.Errorf("lookup: %w", notFound), "get profile")); code != http.StatusNotFound {
+		t.Errorf("expected the inner status, got %d", code)
+	}
+
+	overridden := Wrap(notFound, "forbidden").(*DropboxBaseError).WithHTTPStatus(http.StatusForbidden)
+	if code := HTTPStatus(overridden); code != http.StatusForbidden {
+		t.Errorf("expected the outermost status, got %d", code)
+	}
+
+	if code := HTTPStatus(New("boom")); code != http.StatusInternalServerError {
+		t.Errorf("expected 500 without a status, got %d", code)
+	}
+	if code := HTTPStatus(fmt.Errorf("plain")); code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for a plain error, got %d", code)
+	}
+	if code := HTTPStatus(nil); code != 0 {
+		t.Errorf("expected 0 for nil, got %d", code)
+	}
+}
@@ -0,0 +1,69 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSON representation of an error of a chain, see MarshalJSON.
+type jsonError struct {
+	Message string                 `json:"message"`
+	Context string                 `json:"context,omitempty"`
+	State   map[string]interface{} `json:"state,omitempty"`
+	Stack   string                 `json:"stack,omitempty"`
+	Inner   *jsonError             `json:"inner,omitempty"`
+}
+
+// When set, DefaultError renders errors as JSON (see SetErrorJSONMode).
+var errorJSONMode = false
+
+// SetErrorJSONMode controls whether DefaultError (and thus the Error method
+// of DropboxBaseError) returns the MarshalJSON rendering of the error rather
+// than the multi-line text format, for log pipelines which expect every
+// logged value to be JSON (e.g. log.Println(err)).  Off by default.  This
+// should be called during initialization.
+func SetErrorJSONMode(enabled bool) {
+	errorJSONMode = enabled
+}
+
+// MarshalJSON implements json.Marshaler, for structured log pipelines.  The
+// error is rendered as an object holding its message, context, state and
+// stack, with the error it wraps marshaled recursively under "inner".
+// Wrapped errors which aren't DropboxErrors are rendered as
+// {"message": err.Error()}.  State values which can't be marshaled are
+// replaced by their fmt.Sprint representation, rather than failing the whole
+// error.
+func (e *DropboxBaseError) MarshalJSON() ([]byte, error) {
+	if e == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(newJSONError(e, 0))
+}
+
+func newJSONError(err error, depth int) *jsonError {
+	dbe, ok := err.(DropboxError)
+	if !ok {
+		return &jsonError{Message: err.Error()}
+	}
+
+	out := &jsonError{
+		Message: dbe.GetMessage(),
+		Context: dbe.GetContext(),
+		Stack:   dbe.GetStack(),
+	}
+	if state := renderedState(dbe.GetState()); len(state) > 0 {
+		out.State = make(map[string]interface{}, len(state))
+		for key, value := range state {
+			if _, err := json.Marshal(value); err != nil {
+				value = fmt.Sprint(value)
+			}
+			out.State[key] = value
+		}
+	}
+	// The depth guard protects against custom error types whose chains
+	// cycle.
+	if inner := dbe.GetInner(); inner != nil && depth < maxChainDepth {
+		out.Inner = newJSONError(inner, depth+1)
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	inner := New("connection refused").SetState(map[string]interface{}{
+		"host": "db1",
+		"conn": make(chan int),
+	})
+	outer := Wrap(inner, "query failed").SetState(map[string]interface{}{"table": "users"})
+
+	data, err := json.Marshal(outer)
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+
+	var decoded struct {
+		Message string
+		State   map[string]interface{}
+		Stack   string
+		Inner   *struct {
+			Message string
+			State   map[string]interface{}
+			Stack   string
+			Inner   interface{}
+		}
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected unmarshal error: %v", err)
+	}
+	if decoded.Message != "query failed" || decoded.State["table"] != "users" {
+		t.Errorf("unexpected outer error in %s", data)
+	}
+	if !strings.Contains(decoded.Stack, "TestMarshalJSON") {
+		t.Errorf("expected the stack trace in %s", data)
+	}
+	if decoded.Inner == nil || decoded.Inner.Message != "connection refused" ||
+		decoded.Inner.State["host"] != "db1" || decoded.Inner.Inner != nil {
+		t.Fatalf("unexpected inner error in %s", data)
+	}
+	if conn, ok := decoded.Inner.State["conn"].(string); !ok || !strings.HasPrefix(conn, "0x") {
+		t.Errorf("expected the unserializable value as a string, got %v", decoded.Inner.State["conn"])
+	}
+
+	data, err = json.Marshal(Wrap(fmt.Errorf("timeout"), "fetch failed"))
+	if err != nil || !strings.Contains(string(data), `"inner":{"message":"timeout"}`) {
+		t.Errorf("unexpected rendering of a plain inner error %s (%v)", data, err)
+	}
+}
+
+func TestErrorJSONMode(t *testing.T) {
+	WithSettings(func() {
+		err := Wrap(New("connection refused"), "query failed")
+		if json.Valid([]byte(err.Error())) {
+			t.Fatalf("expected the text format by default, got %s", err.Error())
+		}
+
+		SetErrorJSONMode(true)
+		var decoded struct {
+			Message string
+			Inner   *struct{ Message string }
+		}
+		if e := json.Unmarshal([]byte(err.Error()), &decoded); e != nil {
+			t.Fatalf("expected Error() to be valid JSON (%v): %s", e, err.Error())
+		}
+		if decoded.Message != "query failed" || decoded.Inner == nil ||
+			decoded.Inner.Message != "connection refused" {
+			t.Errorf("unexpected JSON rendering %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package errors
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LogfmtString renders err as space separated key=value pairs, for logfmt
+// based log pipelines.  The output holds the error's message, its code (see
+// GetCode), the location of its original stack frame, and the merged state of
+// the whole chain (outer values win over inner ones), in sorted key order.
+func LogfmtString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	pairs := []string{logfmtPair("message", GetMessage(err))}
+	if code := GetCode(err); code != "" {
+		pairs = append(pairs, logfmtPair("code", code))
+	}
+	if location := topFrame(originalFrames(err)); location != "" {
+		pairs = append(pairs, logfmtPair("location", location))
+	}
+
+	state := mergedState(err)
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs = append(pairs, logfmtPair(key, fmt.Sprint(state[key])))
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+func logfmtPair(key string, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n=\"\\") {
+		value = strconv.Quote(value)
+	}
+	return key + "=" + value
+}
+
+// Returns the states of the whole chain merged into a single map.  Values
+// from outer errors take precedence over values from inner errors.
+func mergedState(err error) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for _, dbe := range DropboxChain(err) {
+		for key, value := range dbe.GetState() {
+			if _, ok := merged[key]; !ok {
+				merged[key] = value
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,46 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLogfmtString(t *testing.T) {
+	inner := New("query failed").SetState(map[string]interface{}{
+		"table":   "users",
+		"attempt": 1,
+	})
+	outer := Wrap(inner, "handler").SetState(map[string]interface{}{
+		"attempt": 2,
+		"path":    "/a b",
+	})
+
+	s := LogfmtString(outer)
+	for _, expected := range []string{
+		`message="handler query failed"`,
+		"table=users",
+		"attempt=2",
+		`path="/a b"`,
+		`location="`,
+		"errors.TestLogfmtString",
+	} {
+		if strings.Index(s, expected) == -1 {
+			t.Errorf("couldn't find %s in:\n%s", expected, s)
+		}
+	}
+	if strings.Index(s, "attempt=1") != -1 {
+		t.Errorf("outer state should take precedence in:\n%s", s)
+	}
+
+	if s := LogfmtString(Wrap(NewWithCode("DB_TIMEOUT", "timeout"), "query failed")); strings.Index(s, "code=DB_TIMEOUT") == -1 {
+		t.Errorf("couldn't find the code in:\n%s", s)
+	}
+
+	if s := LogfmtString(fmt.Errorf("plain")); s != "message=plain" {
+		t.Errorf("unexpected logfmt for plain error: %s", s)
+	}
+	if LogfmtString(nil) != "" {
+		t.Error("expected empty logfmt for nil error")
+	}
+}
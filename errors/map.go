@@ -0,0 +1,30 @@
+package errors
+
+// A MapRule translates errors matched by Match using Wrap.
+type MapRule struct {
+	Match func(error) bool
+	Wrap  func(error) DropboxError
+}
+
+// Map applies the first rule in rules which matches err, and returns the
+// result of its Wrap.  If no rule matches (or err is nil), err is returned
+// unchanged.  This lets API layers declare their error translation as a
+// table, e.g.:
+//
+//	return errors.Map(err, []errors.MapRule{
+//		{
+//			Match: func(e error) bool { return errors.ContainsError(e, sql.ErrNoRows) },
+//			Wrap:  func(e error) errors.DropboxError { return errors.Wrap(e, "user not found") },
+//		},
+//	})
+func Map(err error, rules []MapRule) error {
+	if err == nil {
+		return nil
+	}
+	for _, rule := range rules {
+		if rule.Match(err) {
+			return rule.Wrap(err)
+		}
+	}
+	return err
+}
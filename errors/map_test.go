@@ -0,0 +1,43 @@
+package errors
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	notFound := fmt.Errorf("no rows")
+	timeout := fmt.Errorf("timeout")
+
+	wrapWith := func(msg string) func(error) DropboxError {
+		return func(e error) DropboxError { return Wrap(e, msg) }
+	}
+	rules := []MapRule{
+		{
+			Match: func(e error) bool { return ContainsError(e, notFound) },
+			Wrap:  wrapWith("user not found"),
+		},
+		{
+			Match: func(e error) bool { return ContainsError(e, notFound, timeout) },
+			Wrap:  wrapWith("unavailable"),
+		},
+	}
+
+	mapped := Map(Wrap(notFound, "query failed"), rules)
+	if msg := mapped.(DropboxError).GetMessage(); msg != "user not found" {
+		t.Errorf("first matching rule should win, got %q", msg)
+	}
+
+	mapped = Map(timeout, rules)
+	if msg := mapped.(DropboxError).GetMessage(); msg != "unavailable" {
+		t.Errorf("unexpected mapping %q", msg)
+	}
+
+	other := fmt.Errorf("other")
+	if Map(other, rules) != other {
+		t.Error("unmatched errors should be returned unchanged")
+	}
+	if Map(nil, rules) != nil {
+		t.Error("nil should be returned unchanged")
+	}
+}
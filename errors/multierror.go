@@ -0,0 +1,265 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+)
+
+// A MultiError aggregates several independent errors (e.g. from goroutines
+// fanning out work), keeping the stack and state of each of them.  It is
+// built with Append, and its contained errors are part of its chain (see
+// Flatten), in order.
+type MultiError struct {
+	// Holds the stack captured by the first Append, and the MultiError's own
+	// state and code.
+	base *DropboxBaseError
+	errs []error
+	// Number of errors not kept, see SetMaxAggregatedErrors.
+	dropped int
+}
+
+// Maximum number of errors kept by an aggregate error, 0 for no limit.
+var maxAggregatedErrors = 0
+
+// SetMaxAggregatedErrors bounds the number of errors kept by aggregate
+// errors (MultiError and WrapTasks) to n, so that huge batches don't bloat
+// memory and logs.  Errors past the first n are dropped, and replaced by a
+// single entry counting them (e.g. "3 more errors dropped").  Non-positive
+// values (the default) disable the limit.  This should be called during
+// initialization.
+func SetMaxAggregatedErrors(n int) {
+	maxAggregatedErrors = n
+}
+
+// Entry replacing the errors dropped from an aggregate error.
+type droppedErrors int
+
+func (n droppedErrors) Error() string {
+	if n == 1 {
+		return "1 more error dropped"
+	}
+	return fmt.Sprintf("%d more errors dropped", int(n))
+}
+
+// Returns errs truncated to the maximum number of aggregated errors, with an
+// entry counting the dropped errors.
+func capAggregated(errs []error) []error {
+	if maxAggregatedErrors <= 0 || len(errs) <= maxAggregatedErrors {
+		return errs
+	}
+	capped := append([]error(nil), errs[:maxAggregatedErrors]...)
+	return append(capped, droppedErrors(len(errs)-maxAggregatedErrors))
+}
+
+// Append adds errs to err and returns the aggregate.  If err is a
+// MultiError, the result holds its errors followed by errs, otherwise err
+// itself is the first error.  Nil errors are skipped, and the errors of
+// MultiErrors in errs are added individually.  err is not modified: a new
+// MultiError is returned, which keeps the stack, state and code of err if it
+// is a MultiError.  Use ErrorOrNil to get nil when no error was added.  See
+// SetMaxAggregatedErrors for bounding the number of errors kept.
+//
+// NOTE: Enrichers aren't run on MultiErrors, since they may replace the
+// error they are given.
+func Append(err error, errs ...error) DropboxError {
+	var m *MultiError
+	if prev, ok := err.(*MultiError); ok && prev != nil {
+		m = prev.copy()
+	} else {
+		m = &MultiError{base: &DropboxBaseError{pcs: callers()}}
+		m.add(err)
+	}
+	for _, e := range errs {
+		m.add(e)
+	}
+	return m
+}
+
+// Returns a copy of m holding the same errors, with its own state.
+func (m *MultiError) copy() *MultiError {
+	return &MultiError{
+		base:    m.base.copy(),
+		errs:    append([]error(nil), m.errs...),
+		dropped: m.dropped,
+	}
+}
+
+func (m *MultiError) add(err error) {
+	if IsNil(err) {
+		return
+	}
+	if sub, ok := err.(*MultiError); ok {
+		for _, e := range sub.errs {
+			m.add(e)
+		}
+		m.dropped += sub.dropped
+		return
+	}
+	if maxAggregatedErrors > 0 && len(m.errs) >= maxAggregatedErrors {
+		m.dropped++
+		return
+	}
+	m.errs = append(m.errs, err)
+}
+
+// This returns a copy of the contained errors, followed by an entry counting
+// the dropped errors if any (see SetMaxAggregatedErrors).
+func (m *MultiError) Errors() []error {
+	if m == nil {
+		return nil
+	}
+	errs := append([]error(nil), m.errs...)
+	if m.dropped > 0 {
+		errs = append(errs, droppedErrors(m.dropped))
+	}
+	return errs
+}
+
+// This returns m, or nil if m holds no error.
+func (m *MultiError) ErrorOrNil() error {
+	if m == nil || len(m.errs)+m.dropped == 0 {
+		return nil
+	}
+	return m
+}
+
+// Returns the message m contributes to its chain, i.e. without the messages
+// of the contained errors.
+func (m *MultiError) summary() string {
+	if m == nil {
+		return ""
+	}
+	n := len(m.errs) + m.dropped
+	if n == 1 {
+		return "1 error occurred"
+	}
+	return fmt.Sprintf("%d errors occurred", n)
+}
+
+// This returns the number of errors followed by their messages, e.g.
+// "2 errors occurred: query failed; timeout".
+func (m *MultiError) GetMessage() string {
+	if m == nil {
+		return ""
+	}
+	errs := m.Errors()
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, GetMessage(err))
+	}
+	if len(msgs) == 0 {
+		return m.summary()
+	}
+	return m.summary() + ": " + strings.Join(msgs, "; ")
+}
+
+// Same as GetMessage.  The stacks of the contained errors are available
+// through Errors.
+func (m *MultiError) Error() string {
+	return m.GetMessage()
+}
+
+// This returns the stack trace of the first Append.
+func (m *MultiError) GetStack() string {
+	if m == nil {
+		return ""
+	}
+	return m.base.GetStack()
+}
+
+func (m *MultiError) GetContext() string {
+	if m == nil {
+		return ""
+	}
+	return m.base.GetContext()
+}
+
+// This returns nil, since a MultiError wraps several errors; use Errors or
+// Unwrap instead.
+func (m *MultiError) GetInner() error {
+	return nil
+}
+
+// This returns the contained errors, for the standard library's errors.Is
+// and errors.As.
+func (m *MultiError) Unwrap() []error {
+	return m.Errors()
+}
+
+// This returns true if any of the contained errors matches target, as for
+// the standard library's errors.Is.
+func (m *MultiError) Is(target error) bool {
+	if m == nil {
+		return false
+	}
+	for _, err := range m.errs {
+		if stderrors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MultiError) SetState(state map[string]interface{}) DropboxError {
+	if m == nil {
+		return nil
+	}
+	m.base.SetState(state)
+	return m
+}
+
+func (m *MultiError) GetState() map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.base.GetState()
+}
+
+func (m *MultiError) WithField(key string, value interface{}) DropboxError {
+	if m == nil {
+		return nil
+	}
+	m.base.WithField(key, value)
+	return m
+}
+
+func (m *MultiError) WithFields(fields map[string]interface{}) DropboxError {
+	if m == nil {
+		return nil
+	}
+	m.base.WithFields(fields)
+	return m
+}
+
+func (m *MultiError) GetAnnotatedStates() []map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return annotatedStates(m)
+}
+
+// This returns the MultiError's own code, or the first code found among the
+// contained errors.
+func (m *MultiError) GetCode() string {
+	if m == nil {
+		return ""
+	}
+	if code := m.base.ownCode(); code != "" {
+		return code
+	}
+	for _, err := range m.errs {
+		if code := GetCode(err); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+func (m *MultiError) WithCode(code string) DropboxError {
+	if m == nil {
+		return nil
+	}
+	m.base.WithCode(code)
+	return m
+}
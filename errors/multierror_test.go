@@ -0,0 +1,99 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	timeout := fmt.Errorf("timeout")
+	query := New("query failed").WithField("table", "users")
+
+	err := Append(nil, query, nil)
+	err = Append(err, timeout)
+	m := err.(*MultiError)
+
+	if errs := m.Errors(); len(errs) != 2 || errs[0] != query || errs[1] != timeout {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if msg := m.Error(); msg != "2 errors occurred: query failed; timeout" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if msg := GetMessage(m); msg != "2 errors occurred query failed timeout" {
+		t.Errorf("unexpected chain message %q", msg)
+	}
+	if strings.Index(m.GetStack(), "TestAppend") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", m.GetStack())
+	}
+	if m.ErrorOrNil() != m {
+		t.Error("expected the MultiError itself when it holds errors")
+	}
+
+	if !stderrors.Is(m, timeout) || !m.Is(query) || m.Is(fmt.Errorf("other")) {
+		t.Error("expected Is to match the contained errors only")
+	}
+	if !ContainsError(Wrap(m, "batch failed"), timeout) {
+		t.Error("expected the contained errors to be part of the chain")
+	}
+	if states := m.GetAnnotatedStates(); len(states) != 3 || states[1]["table"] != "users" {
+		t.Errorf("unexpected annotated states %v", states)
+	}
+
+	// Appending doesn't modify the original, and flattens MultiErrors.
+	grown := Append(err, Append(nil, New("third"), New("fourth"))).(*MultiError)
+	if len(grown.Errors()) != 4 || len(m.Errors()) != 2 {
+		t.Errorf("unexpected sizes %d and %d", len(grown.Errors()), len(m.Errors()))
+	}
+	if grown.GetStack() != m.GetStack() {
+		t.Error("expected the stack of the first Append to be kept")
+	}
+
+	empty := Append(nil, nil).(*MultiError)
+	if empty.ErrorOrNil() != nil {
+		t.Error("expected nil for an empty MultiError")
+	}
+	var nilMulti *MultiError
+	if nilMulti.ErrorOrNil() != nil || nilMulti.Errors() != nil {
+		t.Error("expected nil for a nil MultiError")
+	}
+}
+
+func TestMultiErrorCode(t *testing.T) {
+	m := Append(New("first"), NewWithCode("RATE_LIMITED", "second"))
+	if code := GetCode(m); code != "RATE_LIMITED" {
+		t.Errorf("expected the code of a contained error, got %q", code)
+	}
+	if code := GetCode(m.WithCode("PARTIAL_FAILURE")); code != "PARTIAL_FAILURE" {
+		t.Errorf("expected the MultiError's own code, got %q", code)
+	}
+}
+
+func TestMaxAggregatedErrors(t *testing.T) {
+	defer SetMaxAggregatedErrors(0)
+	SetMaxAggregatedErrors(2)
+
+	err := Append(nil, New("a"), New("b"), New("c"))
+	err = Append(err, New("d"), Append(nil, New("e")))
+	m := err.(*MultiError)
+
+	errs := m.Errors()
+	if len(errs) != 3 || errs[0].(DropboxError).GetMessage() != "a" || errs[1].(DropboxError).GetMessage() != "b" {
+		t.Fatalf("expected the first 2 errors and a summary entry, got %v", errs)
+	}
+	if msg := errs[2].Error(); msg != "3 more errors dropped" {
+		t.Errorf("unexpected dropped count %q", msg)
+	}
+	if msg := m.GetMessage(); msg != "5 errors occurred: a; b; 3 more errors dropped" {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	results := map[string]error{}
+	for _, id := range []string{"t1", "t2", "t3", "t4"} {
+		results[id] = fmt.Errorf("failed")
+	}
+	if msgs := GetMessages(WrapTasks(results)); msgs[len(msgs)-1] != "2 more errors dropped" || len(Flatten(WrapTasks(results))) != 7 {
+		t.Errorf("expected WrapTasks to be truncated, got %q", msgs)
+	}
+}
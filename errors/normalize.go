@@ -0,0 +1,50 @@
+package errors
+
+import (
+	"regexp"
+	"sync"
+)
+
+// A NormalizePattern replaces every match of Pattern with Placeholder.
+type NormalizePattern struct {
+	Pattern     *regexp.Regexp
+	Placeholder string
+}
+
+// DefaultNormalizePatterns returns the patterns used by NormalizeMessage
+// unless overridden by SetNormalizePatterns.  They replace quoted literals,
+// UUIDs, hex addresses and numbers, in that order.
+func DefaultNormalizePatterns() []NormalizePattern {
+	return []NormalizePattern{
+		{regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`), "<str>"},
+		{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<id>"},
+		{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<addr>"},
+		{regexp.MustCompile(`\b\d+(?:\.\d+)?`), "<num>"},
+	}
+}
+
+var (
+	normalizePatternsMutex sync.RWMutex
+	normalizePatterns      = DefaultNormalizePatterns()
+)
+
+// SetNormalizePatterns replaces the patterns used by NormalizeMessage.  They
+// are applied in order.
+func SetNormalizePatterns(patterns []NormalizePattern) {
+	normalizePatternsMutex.Lock()
+	defer normalizePatternsMutex.Unlock()
+	normalizePatterns = patterns
+}
+
+// NormalizeMessage replaces the variable parts of msg (ids, numbers, ...)
+// with placeholders, so that messages of the same kind of error normalize to
+// the same string and can be grouped, e.g.
+// `user 42 not found: "bob"` becomes `user <num> not found: <str>`.
+func NormalizeMessage(msg string) string {
+	normalizePatternsMutex.RLock()
+	defer normalizePatternsMutex.RUnlock()
+	for _, p := range normalizePatterns {
+		msg = p.Pattern.ReplaceAllLiteralString(msg, p.Placeholder)
+	}
+	return msg
+}
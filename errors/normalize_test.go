@@ -0,0 +1,34 @@
+package errors
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNormalizeMessage(t *testing.T) {
+	messages := []string{
+		`user 42 not found: "bob" (request 5f0c6a3e-1b2d-4c5e-8f9a-0b1c2d3e4f5a, ptr 0xc000010000)`,
+		`user 7 not found: 'alice' (request 00000000-0000-0000-0000-000000000000, ptr 0x1f)`,
+	}
+	const expected = `user <num> not found: <str> (request <id>, ptr <addr>)`
+	for _, msg := range messages {
+		if normalized := NormalizeMessage(msg); normalized != expected {
+			t.Errorf("unexpected normalized message %q, expected %q", normalized, expected)
+		}
+	}
+
+	if normalized := NormalizeMessage("took 1.5s for v2"); normalized != "took <num>s for v2" {
+		t.Errorf("unexpected normalized message %q", normalized)
+	}
+}
+
+func TestSetNormalizePatterns(t *testing.T) {
+	defer SetNormalizePatterns(DefaultNormalizePatterns())
+
+	SetNormalizePatterns([]NormalizePattern{
+		{regexp.MustCompile(`shard-\d+`), "<shard>"},
+	})
+	if normalized := NormalizeMessage("shard-12 failed 3 times"); normalized != "<shard> failed 3 times" {
+		t.Errorf("unexpected normalized message %q", normalized)
+	}
+}
@@ -0,0 +1,92 @@
+package errors
+
+// Construction options of NewOpt and WrapOpt.
+type options struct {
+	code    string
+	state   map[string]interface{}
+	skip    int
+	noStack bool
+	// Whether the context holds the stacks of all goroutines, as for
+	// NewAllStacks.
+	allStacks bool
+}
+
+// An Option customizes an error built by NewOpt or WrapOpt.
+type Option func(*options)
+
+// WithCode sets the code of the new error (see GetCode).
+func WithCode(code string) Option {
+	return func(o *options) {
+		o.code = code
+	}
+}
+
+// WithState sets the state of the new error.  The map is copied.
+func WithState(state map[string]interface{}) Option {
+	return func(o *options) {
+		if o.state == nil {
+			o.state = make(map[string]interface{}, len(state))
+		}
+		for key, value := range state {
+			o.state[key] = value
+		}
+	}
+}
+
+// WithSkip skips the given number of additional frames from the top of the
+// new error's stack, e.g. 1 for the frame of a helper which builds errors
+// on behalf of its caller.
+func WithSkip(skip int) Option {
+	return func(o *options) {
+		o.skip = skip
+	}
+}
+
+// NoStack disables stack capture for the new error only, e.g. in hot paths
+// where the stack isn't worth its cost:
+//
+//	errors.NewOpt("cache miss", errors.NoStack())
+//
+// Unlike NewSentinel, the error is not Constant.
+func NoStack() Option {
+	return func(o *options) {
+		o.noStack = true
+	}
+}
+
+// NewOpt is the same as New, customized by the given options, e.g.:
+//
+//	errors.NewOpt("too many requests", errors.WithCode("RATE_LIMITED"))
+func NewOpt(msg string, opts ...Option) DropboxError {
+	return newWithOptions(msg, nil, opts)
+}
+
+// WrapOpt is the same as Wrap, customized by the given options.
+func WrapOpt(err error, msg string, opts ...Option) DropboxError {
+	return newWithOptions(msg, err, opts)
+}
+
+// Builds a new error.  This must be called directly by the exported
+// constructor, for the stack to start at the constructor's caller.
+func newWithOptions(msg string, inner error, opts []Option) DropboxError {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	e := &DropboxBaseError{
+		Msg:   sanitizeMessage(msg),
+		State: o.state,
+		inner: inner,
+		code:  o.code,
+	}
+	if o.allStacks {
+		e.Context = allStacks()
+	}
+	if !o.noStack {
+		// Skip runtime.Callers, captureCallers, newWithOptions and the
+		// constructor.
+		e.pcs = captureCallers(4 + o.skip)
+	}
+	return enrich(e)
+}
@@ -0,0 +1,51 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewOpt(t *testing.T) {
+	state := map[string]interface{}{"user_id": 42}
+	err := NewOpt("too many requests", WithCode("RATE_LIMITED"), WithState(state))
+	if err.GetMessage() != "too many requests" || GetCode(err) != "RATE_LIMITED" ||
+		err.GetState()["user_id"] != 42 {
+		t.Errorf("unexpected error %q with code %q and state %v", err.GetMessage(), GetCode(err), err.GetState())
+	}
+	state["user_id"] = 7
+	if err.GetState()["user_id"] != 42 {
+		t.Error("the state option should copy the map")
+	}
+
+	frames := err.(*DropboxBaseError).Frames()
+	if len(frames) == 0 || !strings.HasSuffix(frames[0].Function, "errors.TestNewOpt") {
+		t.Errorf("expected the test function as the first frame, got %v", frames)
+	}
+	helper := func(msg string) DropboxError {
+		return NewOpt(msg, WithSkip(1))
+	}
+	frames = helper("from helper").(*DropboxBaseError).Frames()
+	if len(frames) == 0 || !strings.HasSuffix(frames[0].Function, "errors.TestNewOpt") {
+		t.Errorf("expected the helper's frame to be skipped, got %v", frames)
+	}
+
+	cheap := NewOpt("cheap", NoStack()).(*DropboxBaseError)
+	if stack := cheap.GetStack(); stack != "" || cheap.Constant {
+		t.Errorf("expected a non-constant error without stack, got %q", stack)
+	}
+	if stack := NewOpt("captured").GetStack(); strings.Index(stack, "TestNewOpt") == -1 {
+		t.Errorf("expected other calls to still capture the stack, got %q", stack)
+	}
+}
+
+func TestWrapOpt(t *testing.T) {
+	inner := fmt.Errorf("timeout")
+	err := WrapOpt(inner, "fetch failed", WithCode("UNAVAILABLE"))
+	if err.GetInner() != inner || GetCode(err) != "UNAVAILABLE" {
+		t.Errorf("unexpected error %q", GetMessage(err))
+	}
+	if strings.Index(err.GetStack(), "TestWrapOpt") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", err.GetStack())
+	}
+}
@@ -0,0 +1,60 @@
+package errors
+
+import (
+	"fmt"
+)
+
+// Recover converts a value recovered from a panic into a DropboxError which
+// carries the current stack (which, in a deferred function, includes the
+// frames leading to the panic).  It is meant to be used as:
+//
+//	defer func() {
+//		if r := recover(); r != nil {
+//			err = errors.Recover(r)
+//		}
+//	}()
+//
+// Errors are wrapped, and any other value is rendered into the message.  The
+// new error's state holds a "_panic" marker (see IsPanic), and its context
+// holds the stacks of all goroutines, as for NewAllStacks: unlike for other
+// errors, this is usually worth its cost for panics.  Returns nil for nil.
+func Recover(r interface{}) DropboxError {
+	opts := []Option{
+		WithState(map[string]interface{}{panicKey: true}),
+		func(o *options) { o.allStacks = true },
+	}
+	switch v := r.(type) {
+	case nil:
+		return nil
+	case error:
+		return newWithOptions("panic", v, opts)
+	default:
+		return newWithOptions(fmt.Sprintf("panic: %v", v), nil, opts)
+	}
+}
+
+// State key marking errors built by Recover.
+const panicKey = "_panic"
+
+// IsPanic returns whether any error in err's chain was converted from a
+// panic by Recover (or RecoverHandler).  Panics usually indicate bugs rather
+// than expected failures, so they may deserve more urgent handling.
+func IsPanic(err error) bool {
+	for _, dbe := range DropboxChain(err) {
+		if marked, _ := dbe.GetState()[panicKey].(bool); marked {
+			return true
+		}
+	}
+	return false
+}
+
+// RecoverHandler runs fn and returns its error, converting a panic in fn
+// into an error as Recover does.
+func RecoverHandler(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Recover(r)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,72 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func panicking(value interface{}) error {
+	panic(value)
+}
+
+func TestRecoverHandler(t *testing.T) {
+	cause := fmt.Errorf("nil map")
+	for _, c := range []struct {
+		value   interface{}
+		message string
+	}{
+		{"boom", "panic: boom"},
+		{cause, "panic nil map"},
+		{42, "panic: 42"},
+	} {
+		err := RecoverHandler(func() error { return panicking(c.value) })
+		dbe, ok := err.(DropboxError)
+		if !ok {
+			t.Errorf("expected a DropboxError for %v, got %T", c.value, err)
+			continue
+		}
+		if msg := GetMessage(dbe); msg != c.message {
+			t.Errorf("unexpected message %q, expected %q", msg, c.message)
+		}
+		if !strings.Contains(dbe.GetStack(), "errors.panicking") {
+			t.Errorf("expected the panic site in the stack:\n%s", dbe.GetStack())
+		}
+	}
+
+	err := RecoverHandler(func() error { return panicking(cause) })
+	if !ContainsError(err, cause) {
+		t.Error("expected the recovered error in the chain")
+	}
+
+	original := New("already wrapped")
+	if err := RecoverHandler(func() error { return panicking(original) }); !ContainsError(err, original) || !IsPanic(err) {
+		t.Errorf("expected a DropboxError to be wrapped and marked, got %v", err)
+	}
+	if err := RecoverHandler(func() error { return cause }); err != cause {
+		t.Errorf("expected fn's error without a panic, got %v", err)
+	}
+	if Recover(nil) != nil {
+		t.Error("expected nil for nil")
+	}
+}
+
+func TestIsPanic(t *testing.T) {
+	err := RecoverHandler(func() error { return panicking("boom") })
+	if !IsPanic(err) || !IsPanic(Wrap(err, "handler failed")) {
+		t.Error("expected a panic-derived error to be detected")
+	}
+	if IsPanic(Wrap(fmt.Errorf("timeout"), "handler failed")) || IsPanic(nil) {
+		t.Error("expected a normal error not to be detected as a panic")
+	}
+}
+
+func TestRecoverContext(t *testing.T) {
+	err := RecoverHandler(func() error { return panicking("boom") }).(DropboxError)
+	if strings.Index(err.GetContext(), "goroutine ") == -1 {
+		t.Errorf("expected the goroutine dump in the context of a panic:\n%s", err.GetContext())
+	}
+	if context := New("normal").GetContext(); context != "" {
+		t.Errorf("expected no context for a normal error, got:\n%s", context)
+	}
+}
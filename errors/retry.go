@@ -0,0 +1,92 @@
+package errors
+
+// This marks whether the failure is worth retrying, overriding the
+// classification of the inner errors, and returns the error for chaining.
+// See IsRetryable.
+func (e *DropboxBaseError) WithRetryable(retryable bool) DropboxError {
+	if e == nil {
+		return nil
+	}
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+	e.retryable = retryable
+	e.retryableSet = true
+	return e
+}
+
+// Returns the retryable flag set on e itself, and whether one is set.
+func (e *DropboxBaseError) ownRetryable() (bool, bool) {
+	e.stateMutex.RLock()
+	defer e.stateMutex.RUnlock()
+	return e.retryable, e.retryableSet
+}
+
+// IsRetryable returns whether err is worth retrying, e.g.:
+//
+//	if errors.IsRetryable(err) { backoffAndRetry() }
+//
+// The first (i.e. outermost) error of the chain which is classified decides:
+// either a DropboxBaseError marked with WithRetryable, or an error
+// implementing Temporary() bool (e.g. from the net package).  Errors are not
+// retryable unless classified.
+func IsRetryable(err error) bool {
+	for _, e := range walk(err) {
+		if dbe, ok := e.(*DropboxBaseError); ok {
+			if retryable, ok := dbe.ownRetryable(); ok {
+				return retryable
+			}
+			continue
+		}
+		if t, ok := e.(interface{ Temporary() bool }); ok {
+			return t.Temporary()
+		}
+	}
+	return false
+}
+
+// A RetryPolicy selects which errors are retried, see WouldRetry.
+type RetryPolicy struct {
+	// Whether the marks set with WithRetryable are honored.
+	Marked bool
+	// Whether errors implementing Temporary() bool are honored.
+	Temporary bool
+	// Codes (see GetCode) and routing categories (see RoutingCategory) of
+	// errors to retry.
+	Codes      []string
+	Categories []string
+}
+
+// WouldRetry returns whether err would be retried under policy, mirroring
+// IsRetryable with only the classifications enabled by the policy: the first
+// (i.e. outermost) error of the chain which is classified decides.  Errors
+// which aren't classified are retried if their code or routing category is
+// listed by the policy.  This gives a single, testable predicate for retry
+// decisions.
+func WouldRetry(err error, policy RetryPolicy) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range walk(err) {
+		if dbe, ok := e.(*DropboxBaseError); ok {
+			if retryable, ok := dbe.ownRetryable(); ok && policy.Marked {
+				return retryable
+			}
+			continue
+		}
+		if t, ok := e.(interface{ Temporary() bool }); ok && policy.Temporary {
+			return t.Temporary()
+		}
+	}
+	code, category := GetCode(err), RoutingCategory(err)
+	return (code != "" && containsString(policy.Codes, code)) ||
+		(category != "" && containsString(policy.Categories, category))
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
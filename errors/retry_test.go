@@ -0,0 +1,65 @@
+package errors
+
+import (
+	"fmt"
+	"testing"
+)
+
+type temporaryError struct{ temporary bool }
+
+func (e temporaryError) Error() string   { return "temporary" }
+func (e temporaryError) Temporary() bool { return e.temporary }
+
+func TestIsRetryable(t *testing.T) {
+	unavailable := New("service unavailable").(*DropboxBaseError).WithRetryable(true)
+	if !IsRetryable(unavailable) || !IsRetryable(Wrap(unavailable, "fetch failed")) {
+		t.Error("expected a retryable error through wrappers")
+	}
+
+	overridden := Wrap(unavailable, "quota exhausted").(*DropboxBaseError).WithRetryable(false)
+	if IsRetryable(overridden) {
+		t.Error("expected the outermost flag to win")
+	}
+
+	if !IsRetryable(Wrap(fmt.Errorf("dial: %w", temporaryError{true}), "connect failed")) {
+		t.Error("expected Temporary() errors to be retryable")
+	}
+	if IsRetryable(Wrap(temporaryError{false}, "connect failed")) {
+		t.Error("expected non-temporary errors not to be retryable")
+	}
+
+	if IsRetryable(New("boom")) || IsRetryable(fmt.Errorf("plain")) || IsRetryable(nil) {
+		t.Error("expected errors not to be retryable by default")
+	}
+}
+
+func TestWouldRetry(t *testing.T) {
+	marked := Wrap(New("service unavailable").(*DropboxBaseError).WithRetryable(true), "fetch failed")
+	temporary := Wrap(temporaryError{true}, "connect failed")
+
+	markedOnly := RetryPolicy{Marked: true}
+	if !WouldRetry(marked, markedOnly) || WouldRetry(temporary, markedOnly) {
+		t.Error("expected only marked errors to be retried")
+	}
+	temporaryOnly := RetryPolicy{Temporary: true}
+	if WouldRetry(marked, temporaryOnly) || !WouldRetry(temporary, temporaryOnly) {
+		t.Error("expected only temporary errors to be retried")
+	}
+
+	policy := RetryPolicy{Codes: []string{"RATE_LIMITED"}, Categories: []string{"billing"}}
+	if !WouldRetry(Wrap(NewWithCode("RATE_LIMITED", "slow down"), "fetch failed"), policy) {
+		t.Error("expected errors with a listed code to be retried")
+	}
+	if !WouldRetry(WrapCategory(fmt.Errorf("timeout"), "billing", "charge failed"), policy) {
+		t.Error("expected errors with a listed category to be retried")
+	}
+	if WouldRetry(New("boom"), policy) || WouldRetry(nil, policy) {
+		t.Error("expected unlisted errors not to be retried")
+	}
+
+	policy.Marked = true
+	vetoed := NewWithCode("RATE_LIMITED", "quota exhausted").(*DropboxBaseError).WithRetryable(false)
+	if WouldRetry(vetoed, policy) {
+		t.Error("expected a retryable mark to take precedence over codes")
+	}
+}
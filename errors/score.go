@@ -0,0 +1,65 @@
+package errors
+
+import (
+	"net/http"
+)
+
+// Computes the triage score of an error, see SetScoreFunc.
+var scoreFunc = DefaultScore
+
+// SetScoreFunc customizes how Score computes the triage score of an error.
+// The default is DefaultScore.  This should be called during initialization.
+func SetScoreFunc(f func(DropboxError) int) {
+	scoreFunc = f
+}
+
+// Score returns a severity-weighted score for err, for prioritizing triage
+// queues: the higher the score, the more urgent the error.  The score is
+// computed by the function set with SetScoreFunc (DefaultScore by default).
+// Errors which aren't DropboxErrors are wrapped without adding a message
+// before being scored.  Returns 0 for a nil error.
+func Score(err error) int {
+	if err == nil {
+		return 0
+	}
+	dbe, ok := err.(DropboxError)
+	if !ok {
+		dbe = &DropboxBaseError{inner: err}
+	}
+	return scoreFunc(dbe)
+}
+
+// DefaultScore scores err by adding up:
+//
+//	100 if err was converted from a panic (see IsPanic)
+//	 50 if its HTTP status (see HTTPStatus) is a server error (5xx)
+//	 20 if its HTTP status is a client error (4xx)
+//	-40 if it is expected (see IsExpected)
+//	  1 per error of its chain (see Flatten), up to 10
+//
+// so that bugs outrank failures caused by clients, and both outrank business
+// as usual.  Returns 0 for a nil error.
+func DefaultScore(err DropboxError) int {
+	if err == nil {
+		return 0
+	}
+
+	score := 0
+	if IsPanic(err) {
+		score += 100
+	}
+	switch status := HTTPStatus(err); {
+	case status >= http.StatusInternalServerError:
+		score += 50
+	case status >= http.StatusBadRequest:
+		score += 20
+	}
+	if IsExpected(err) {
+		score -= 40
+	}
+	depth := len(walk(err))
+	if depth > 10 {
+		depth = 10
+	}
+	return score + depth
+}
@@ -0,0 +1,38 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	panicked := Recover("nil map").(*DropboxBaseError).WithHTTPStatus(http.StatusInternalServerError)
+	client := MarkExpected(New("invalid email").(*DropboxBaseError).WithHTTPStatus(http.StatusBadRequest))
+	if Score(panicked) <= Score(client) {
+		t.Errorf("expected the panic (%d) to outrank the expected client error (%d)",
+			Score(panicked), Score(client))
+	}
+
+	server := Wrap(fmt.Errorf("connection refused"), "query failed")
+	if Score(panicked) <= Score(server) || Score(server) <= Score(client) {
+		t.Errorf("expected the server error (%d) between the panic and the client error",
+			Score(server))
+	}
+	if Score(nil) != 0 {
+		t.Error("expected 0 for a nil error")
+	}
+	if Score(fmt.Errorf("timeout")) <= 0 {
+		t.Error("expected plain errors to be scored")
+	}
+
+	WithSettings(func() {
+		SetScoreFunc(func(err DropboxError) int { return len(GetMessage(err)) })
+		if score := Score(server); score != len("query failed connection refused") {
+			t.Errorf("expected the custom score function to be used, got %d", score)
+		}
+	})
+	if Score(server) == len("query failed connection refused") {
+		t.Error("the score function was not restored")
+	}
+}
@@ -0,0 +1,74 @@
+package errors
+
+// A Settings is a snapshot of the package settings changed by the Set*
+// functions (SetSanitizeMessages, SetSymbolizer, ...), which can be restored
+// later.  Registrations (RegisterEnricher, RegisterCategoryChannel and
+// CachedNew) aren't part of it.
+type Settings struct {
+	sanitizeMessages       bool
+	showRootType           bool
+	initialStackBufferSize int
+	titleMaxLength         int
+	normalizePatterns      []NormalizePattern
+	shortFunctionNames     bool
+	symbolizer             func(pcs []uintptr) string
+	flattenStateRendering  bool
+	spanExtractor          SpanExtractor
+	maxAggregatedErrors    int
+	errorJSONMode          bool
+	scoreFunc              func(DropboxError) int
+}
+
+// CurrentSettings returns a snapshot of the current package settings.
+func CurrentSettings() Settings {
+	normalizePatternsMutex.RLock()
+	patterns := normalizePatterns
+	normalizePatternsMutex.RUnlock()
+	spanExtractorMutex.RLock()
+	extractor := spanExtractor
+	spanExtractorMutex.RUnlock()
+
+	return Settings{
+		sanitizeMessages:       sanitizeMessages,
+		showRootType:           showRootType,
+		initialStackBufferSize: initialStackBufferSize,
+		titleMaxLength:         titleMaxLength,
+		normalizePatterns:      patterns,
+		shortFunctionNames:     shortFunctionNames,
+		symbolizer:             symbolizer,
+		flattenStateRendering:  flattenStateRendering,
+		spanExtractor:          extractor,
+		maxAggregatedErrors:    maxAggregatedErrors,
+		errorJSONMode:          errorJSONMode,
+		scoreFunc:              scoreFunc,
+	}
+}
+
+// Restore reverts the package settings to the snapshot.
+func (s Settings) Restore() {
+	sanitizeMessages = s.sanitizeMessages
+	showRootType = s.showRootType
+	initialStackBufferSize = s.initialStackBufferSize
+	titleMaxLength = s.titleMaxLength
+	SetNormalizePatterns(s.normalizePatterns)
+	shortFunctionNames = s.shortFunctionNames
+	symbolizer = s.symbolizer
+	flattenStateRendering = s.flattenStateRendering
+	SetSpanExtractor(s.spanExtractor)
+	maxAggregatedErrors = s.maxAggregatedErrors
+	errorJSONMode = s.errorJSONMode
+	scoreFunc = s.scoreFunc
+}
+
+// WithSettings runs fn, then restores the package settings as they were
+// before, even if fn panics.  This keeps tests which call the Set* functions
+// from leaking settings into each other, e.g.:
+//
+//	errors.WithSettings(func() {
+//		errors.SetShowRootType(true)
+//		...
+//	})
+func WithSettings(fn func()) {
+	defer CurrentSettings().Restore()
+	fn()
+}
@@ -0,0 +1,49 @@
+package errors
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithSettings(t *testing.T) {
+	before := CurrentSettings()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to propagate")
+			}
+		}()
+		WithSettings(func() {
+			SetSanitizeMessages(true)
+			SetShowRootType(true)
+			SetTitleMaxLength(5)
+			SetNormalizePatterns(nil)
+			SetShortFunctionNames(true)
+			SetSymbolizer(func(pcs []uintptr) string { return "custom" })
+			SetFlattenStateRendering(true)
+			SetSpanExtractor(func(ctx context.Context) (string, string) { return "t", "s" })
+			SetMaxAggregatedErrors(3)
+			SetErrorJSONMode(true)
+			if err := SetInitialStackBufferSize(4096); err != nil {
+				t.Fatal(err)
+			}
+			panic("boom")
+		})
+	}()
+
+	after := CurrentSettings()
+	if after.sanitizeMessages || after.showRootType || after.shortFunctionNames ||
+		after.flattenStateRendering || after.spanExtractor != nil || after.maxAggregatedErrors != 0 || after.errorJSONMode ||
+		after.titleMaxLength != before.titleMaxLength ||
+		after.initialStackBufferSize != before.initialStackBufferSize ||
+		len(after.normalizePatterns) != len(before.normalizePatterns) {
+		t.Errorf("settings were not restored: %+v", after)
+	}
+	if stack := New("restored").GetStack(); stack == "custom" {
+		t.Error("the symbolizer was not restored")
+	}
+	if title := Title(New("restored title")); title != "restored title" {
+		t.Errorf("the title length was not restored, got %q", title)
+	}
+}
@@ -0,0 +1,329 @@
+package errors
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// A single frame of a stack trace.
+type StackFrame struct {
+	// Fully qualified function name, e.g.
+	// "github.com/saleswise/app.(*Server).Handle".
+	Function string
+	File     string
+	Line     int
+}
+
+// Parses a stack trace as formatted by runtime.Stack into frames, innermost
+// (i.e. most recent call) first.  The goroutine header line is skipped, as
+// are lines which can't be parsed.
+func parseStack(stack string) []StackFrame {
+	lines := strings.Split(stack, "\n")
+	var frames []StackFrame
+	for i := 0; i < len(lines); i++ {
+		function := strings.TrimSpace(lines[i])
+		if function == "" || strings.HasPrefix(function, "goroutine ") {
+			continue
+		}
+		if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "\t") {
+			continue
+		}
+
+		// e.g. "created by main.main in goroutine 1"
+		if strings.HasPrefix(function, "created by ") {
+			function = strings.TrimPrefix(function, "created by ")
+			if idx := strings.Index(function, " in goroutine "); idx >= 0 {
+				function = function[:idx]
+			}
+		} else if strings.HasSuffix(function, ")") {
+			if idx := strings.LastIndex(function, "("); idx > 0 {
+				function = function[:idx]
+			}
+		}
+
+		// e.g. "\t/src/app/server.go:42 +0x1d"
+		location := strings.TrimSpace(lines[i+1])
+		if idx := strings.LastIndex(location, " +0x"); idx >= 0 {
+			location = location[:idx]
+		}
+		file := location
+		line := 0
+		if idx := strings.LastIndex(location, ":"); idx >= 0 {
+			if n, err := strconv.Atoi(location[idx+1:]); err == nil {
+				file = location[:idx]
+				line = n
+			}
+		}
+
+		frames = append(frames, StackFrame{
+			Function: function,
+			File:     file,
+			Line:     line,
+		})
+		i++
+	}
+	return frames
+}
+
+// AppStack returns the frames of err's original stack trace (see
+// DefaultError) whose function belongs to the application, i.e. starts with
+// one of the given import path prefixes.  Frames are innermost first.
+func AppStack(err error, appPrefixes []string) []StackFrame {
+	var out []StackFrame
+	for _, frame := range originalFrames(err) {
+		if hasAnyPrefix(frame.Function, appPrefixes) {
+			out = append(out, frame)
+		}
+	}
+	return out
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BlameFrame returns the first frame of err's original stack trace (see
+// DefaultError), innermost first, whose function does not start with any of
+// skipPrefixes.  Listing framework and middleware import paths as prefixes
+// yields the application frame to blame for the error.
+func BlameFrame(err error, skipPrefixes []string) (StackFrame, bool) {
+	for _, frame := range originalFrames(err) {
+		if !hasAnyPrefix(frame.Function, skipPrefixes) {
+			return frame, true
+		}
+	}
+	return StackFrame{}, false
+}
+
+// CompactStack renders err's original stack trace (see DefaultError) as a
+// single line of "file:line" pairs, without function names or directories,
+// e.g. "query.go:12 < server.go:42 < main.go:10".  Frames are innermost
+// first, i.e. each frame was called from the frame following it.
+func CompactStack(err error) string {
+	frames := originalFrames(err)
+	parts := make([]string, 0, len(frames))
+	for _, frame := range frames {
+		parts = append(parts, fmt.Sprintf("%s:%d", path.Base(frame.File), frame.Line))
+	}
+	return strings.Join(parts, " < ")
+}
+
+// HasOriginalStack returns whether the root cause of err's chain (see
+// RootCause) is a DropboxError with a non-empty stack.  This is false when
+// an error was stringified and re-created as a plain error (e.g.
+// errors.New(err.Error()) with the standard library) at a lossy boundary,
+// even if the chain was wrapped again afterwards, since the wrappers' stacks
+// don't reach back to where the failure originated.
+func HasOriginalStack(err error) bool {
+	dbe, ok := RootCause(err).(DropboxError)
+	return ok && dbe.GetStack() != ""
+}
+
+// Returns the frames of err's own stack, innermost first.
+func errorFrames(err DropboxError) []StackFrame {
+	if e, ok := err.(*DropboxBaseError); ok {
+		return e.Frames()
+	}
+	return parseStack(err.GetStack())
+}
+
+// When set, GetStackFrames strips package paths from function names.
+var shortFunctionNames = false
+
+// SetShortFunctionNames controls whether the frames returned by
+// GetStackFrames hold short function names without the package path, e.g.
+// "(*Server).Handle" instead of "github.com/saleswise/app.(*Server).Handle".
+// This should be called during initialization.
+func SetShortFunctionNames(short bool) {
+	shortFunctionNames = short
+}
+
+// GetStackFrames returns the frames of the error's stack trace in
+// callee-to-caller order: the first frame is the error site (the caller of
+// the constructor), followed by its callers, up to the goroutine's entry
+// point.  The order is the runtime's and never changes between calls, so
+// stacks can be diffed frame by frame.  Function names are fully qualified,
+// including the receiver type of methods (e.g.
+// "github.com/saleswise/app.(*Server).Handle"), unless SetShortFunctionNames
+// is enabled.
+func (e *DropboxBaseError) GetStackFrames() []StackFrame {
+	if e == nil {
+		return nil
+	}
+	frames := e.stackFrames()
+	if shortFunctionNames {
+		for i := range frames {
+			frames[i].Function = shortFunctionName(frames[i].Function)
+		}
+	}
+	return frames
+}
+
+// Frames returns the frames of the error's stack trace, innermost first, with
+// fully qualified function names regardless of SetShortFunctionNames.  The
+// frames come from the program counters captured at creation when
+// available, or are parsed from the formatted Stack otherwise.
+func (e *DropboxBaseError) Frames() []StackFrame {
+	if e == nil {
+		return nil
+	}
+	return e.stackFrames()
+}
+
+// Strips the package path from a fully qualified function name.
+func shortFunctionName(function string) string {
+	// The package path ends at the first dot after the last slash; dots
+	// before that may be part of the domain (e.g. github.com), and the
+	// runtime escapes dots in the last path element (e.g. check%2ev1).
+	name := function
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if idx := strings.Index(name, "."); idx >= 0 {
+		return name[idx+1:]
+	}
+	return function
+}
+
+// Returns the program counters of the caller of the function calling
+// callers, and of all of its callers.  The error constructors use this to
+// capture the stack of their caller, excluding themselves.
+func callers() []uintptr {
+	// Skip runtime.Callers, captureCallers, callers and the constructor.
+	return captureCallers(4)
+}
+
+// Returns the program counters of the current goroutine's stack, skipping
+// skip frames as runtime.Callers does (i.e. 0 is runtime.Callers and 1 is
+// captureCallers).
+func captureCallers(skip int) []uintptr {
+	pcs := make([]uintptr, 32)
+	for {
+		n := runtime.Callers(skip, pcs)
+		if n < len(pcs) {
+			return pcs[:n]
+		}
+		pcs = make([]uintptr, len(pcs)*2)
+	}
+}
+
+// Number of symbolizations performed by symbolize, so that tests can check
+// that stacks are symbolized lazily, and only once per error.
+var stackSymbolizeCount int64
+
+// Symbolizes the captured program counters, if any, into frames, and
+// formats them into Stack (see SetSymbolizer) unless it has been set
+// already.  Only the first call does any work.
+func (e *DropboxBaseError) symbolize() {
+	e.symbolizeOnce.Do(func() {
+		if len(e.pcs) == 0 {
+			return
+		}
+		atomic.AddInt64(&stackSymbolizeCount, 1)
+		e.frames = runtimeFrames(e.pcs)
+		if e.Stack == "" {
+			e.Stack = symbolizer(e.pcs)
+		}
+	})
+}
+
+// Returns the frames of the given program counters, innermost first.
+func runtimeFrames(pcs []uintptr) []runtime.Frame {
+	var out []runtime.Frame
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		// The goroutine entry point is noise (runtime.Stack omits it too).
+		if frame.Function != "runtime.goexit" {
+			out = append(out, frame)
+		}
+		if !more {
+			break
+		}
+	}
+	return out
+}
+
+// Renders captured program counters into the string returned by GetStack.
+var symbolizer = DefaultSymbolizer
+
+// SetSymbolizer customizes how the program counters captured by the
+// constructors are rendered into the stack trace returned by GetStack (e.g.
+// to strip vendor paths, or to link frames to source URLs).  The default is
+// DefaultSymbolizer.  StackFrames and GetStackFrames aren't affected.  This
+// should be called during initialization.
+func SetSymbolizer(s func(pcs []uintptr) string) {
+	symbolizer = s
+}
+
+// DefaultSymbolizer renders program counters like runtime.Stack does, minus
+// argument values, pc offsets and the goroutine header.
+func DefaultSymbolizer(pcs []uintptr) string {
+	return formatFrames(runtimeFrames(pcs))
+}
+
+// Formats frames like runtime.Stack does, minus argument values, pc offsets
+// and the goroutine header.
+func formatFrames(frames []runtime.Frame) string {
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		fmt.Fprintf(&buf, "%s(...)\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+	}
+	return buf.String()
+}
+
+// StackFrames returns the frames of the stack trace captured when the error
+// was created, innermost first.  The program counters captured at creation
+// are only symbolized when the stack is first needed (by StackFrames,
+// GetStack, Error, ...), which keeps creating errors that are never logged
+// cheap.  Returns nil for errors whose stack wasn't captured as program
+// counters (e.g. built with Reconstruct).
+func (e *DropboxBaseError) StackFrames() []runtime.Frame {
+	if e == nil {
+		return nil
+	}
+	e.symbolize()
+	if e.frames == nil {
+		return nil
+	}
+	return append([]runtime.Frame(nil), e.frames...)
+}
+
+// ProgramCounters returns a copy of the program counters captured when the
+// error was created, for custom symbolization or external tooling (e.g.
+// pprof), see runtime.CallersFrames.  Returns an empty slice for errors whose
+// stack wasn't captured as program counters.
+func (e *DropboxBaseError) ProgramCounters() []uintptr {
+	if e == nil {
+		return []uintptr{}
+	}
+	return append([]uintptr{}, e.pcs...)
+}
+
+// Returns the frames of the error's stack, from the captured program
+// counters if any, or parsed from Stack otherwise.
+func (e *DropboxBaseError) stackFrames() []StackFrame {
+	e.symbolize()
+	if len(e.frames) == 0 {
+		return parseStack(e.Stack)
+	}
+	out := make([]StackFrame, 0, len(e.frames))
+	for _, frame := range e.frames {
+		out = append(out, StackFrame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+		})
+	}
+	return out
+}
@@ -0,0 +1,365 @@
+package errors
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testStack = "goroutine 1 [running]:\n" +
+	"github.com/saleswise/app/db.Query(0xc000010000, 0x1)\n" +
+	"\t/src/app/db/query.go:12 +0x1d\n" +
+	"github.com/lib/pq.(*conn).query(...)\n" +
+	"\t/src/lib/pq/conn.go:80\n" +
+	"github.com/saleswise/app.(*Server).Handle(0xc000010000)\n" +
+	"\t/src/app/server.go:42 +0x25\n" +
+	"net/http.(*conn).serve(0xc0000a0000)\n" +
+	"\t/usr/local/go/src/net/http/server.go:1995 +0x612\n" +
+	"created by net/http.(*Server).Serve in goroutine 1\n" +
+	"\t/usr/local/go/src/net/http/server.go:3089 +0x5ed\n"
+
+func TestParseStack(t *testing.T) {
+	expected := []StackFrame{
+		{"github.com/saleswise/app/db.Query", "/src/app/db/query.go", 12},
+		{"github.com/lib/pq.(*conn).query", "/src/lib/pq/conn.go", 80},
+		{"github.com/saleswise/app.(*Server).Handle", "/src/app/server.go", 42},
+		{"net/http.(*conn).serve", "/usr/local/go/src/net/http/server.go", 1995},
+		{"net/http.(*Server).Serve", "/usr/local/go/src/net/http/server.go", 3089},
+	}
+	if frames := parseStack(testStack); !reflect.DeepEqual(frames, expected) {
+		t.Errorf("unexpected frames:\n%v\nexpected:\n%v", frames, expected)
+	}
+}
+
+func TestAppStack(t *testing.T) {
+	err := &DropboxBaseError{Msg: "query failed", Stack: testStack}
+	frames := AppStack(err, []string{"github.com/saleswise/"})
+	if len(frames) != 2 ||
+		frames[0].Function != "github.com/saleswise/app/db.Query" ||
+		frames[1].Function != "github.com/saleswise/app.(*Server).Handle" {
+		t.Errorf("unexpected app frames: %v", frames)
+	}
+
+	frames = AppStack(New("live"), []string{"_/", "github.com/saleswise/errors"})
+	if len(frames) == 0 || strings.Index(frames[0].Function, "TestAppStack") == -1 {
+		t.Errorf("expected the test function as the first app frame: %v", frames)
+	}
+}
+
+func TestBlameFrame(t *testing.T) {
+	err := &DropboxBaseError{Msg: "query failed", Stack: testStack}
+
+	frame, ok := BlameFrame(err, []string{"github.com/lib/", "net/http."})
+	if !ok || frame.Function != "github.com/saleswise/app/db.Query" {
+		t.Errorf("unexpected blame frame %v", frame)
+	}
+
+	frame, ok = BlameFrame(err, []string{"github.com/saleswise/app/db.", "github.com/lib/"})
+	if !ok || frame.Function != "github.com/saleswise/app.(*Server).Handle" || frame.Line != 42 {
+		t.Errorf("unexpected blame frame %v", frame)
+	}
+
+	if _, ok := BlameFrame(err, []string{""}); ok {
+		t.Error("expected no blame frame when every frame is skipped")
+	}
+}
+
+func TestCompactStack(t *testing.T) {
+	err := Wrap(&DropboxBaseError{Msg: "query failed", Stack: testStack}, "handler failed")
+	expected := "query.go:12 < conn.go:80 < server.go:42 < server.go:1995 < server.go:3089"
+	if s := CompactStack(err); s != expected {
+		t.Errorf("unexpected compact stack %q, expected %q", s, expected)
+	}
+
+	if s := CompactStack(New("live")); !strings.HasPrefix(s, "stack_test.go:") {
+		t.Errorf("expected the test file first in %q", s)
+	}
+	if s := CompactStack(nil); s != "" {
+		t.Errorf("expected an empty compact stack for nil, got %q", s)
+	}
+}
+
+type stackTestServer struct{}
+
+func (s *stackTestServer) handle() DropboxError { return New("handler failed") }
+
+func TestGetStackFrames(t *testing.T) {
+	defer SetShortFunctionNames(false)
+
+	err := (&stackTestServer{}).handle().(*DropboxBaseError)
+	frames := err.GetStackFrames()
+	if len(frames) < 2 {
+		t.Fatalf("expected at least 2 frames, got %v", frames)
+	}
+	if !strings.HasSuffix(frames[0].Function, "errors.(*stackTestServer).handle") ||
+		frames[0].Function == "(*stackTestServer).handle" {
+		t.Errorf("expected the fully qualified method name, got %q", frames[0].Function)
+	}
+	if !strings.HasSuffix(frames[1].Function, "errors.TestGetStackFrames") {
+		t.Errorf("expected the test function as caller, got %q", frames[1].Function)
+	}
+
+	SetShortFunctionNames(true)
+	frames = err.GetStackFrames()
+	if frames[0].Function != "(*stackTestServer).handle" || frames[1].Function != "TestGetStackFrames" {
+		t.Errorf("unexpected short function names %q, %q", frames[0].Function, frames[1].Function)
+	}
+	if !strings.HasSuffix(frames[0].File, "stack_test.go") {
+		t.Errorf("short function names should not affect files, got %q", frames[0].File)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	defer SetShortFunctionNames(false)
+	SetShortFunctionNames(true)
+
+	err := &DropboxBaseError{Msg: "query failed", Stack: testStack}
+	frames := err.Frames()
+	if len(frames) != 5 {
+		t.Fatalf("expected 5 frames, got %v", frames)
+	}
+	if frames[0] != (StackFrame{"github.com/saleswise/app/db.Query", "/src/app/db/query.go", 12}) {
+		t.Errorf("unexpected innermost frame %v", frames[0])
+	}
+	if frames[4] != (StackFrame{"net/http.(*Server).Serve", "/usr/local/go/src/net/http/server.go", 3089}) {
+		t.Errorf("unexpected outermost frame %v", frames[4])
+	}
+
+	frames = New("live").(*DropboxBaseError).Frames()
+	if len(frames) == 0 || !strings.HasSuffix(frames[0].Function, "errors.TestFrames") ||
+		!strings.HasSuffix(frames[0].File, "stack_test.go") || frames[0].Line == 0 {
+		t.Errorf("expected the test function as the first frame, got %v", frames)
+	}
+
+	var nilErr *DropboxBaseError
+	if nilErr.Frames() != nil {
+		t.Error("expected no frames for a nil error")
+	}
+}
+
+func TestStackFrames(t *testing.T) {
+	err := New("lazy").(*DropboxBaseError)
+	if err.frames != nil || err.Stack != "" {
+		t.Error("frames should not be symbolized before they are needed")
+	}
+
+	frames := err.StackFrames()
+	if len(frames) == 0 || !strings.HasSuffix(frames[0].Function, "errors.TestStackFrames") {
+		t.Fatalf("expected the test function as the first frame, got %v", frames)
+	}
+	for _, frame := range frames {
+		if frame.Function == "runtime.goexit" {
+			t.Error("runtime.goexit should not be part of the frames")
+		}
+	}
+
+	stack := err.GetStack()
+	if !strings.Contains(stack, "errors.TestStackFrames(...)\n\t") ||
+		!strings.Contains(stack, "stack_test.go:") {
+		t.Errorf("unexpected formatted stack %q", stack)
+	}
+	if parsed := parseStack(stack); parsed[0].Function != frames[0].Function ||
+		parsed[0].Line != frames[0].Line {
+		t.Errorf("formatted stack should parse back into the frames, got %v", parsed[0])
+	}
+
+	reconstructed := &DropboxBaseError{Msg: "query failed", Stack: testStack}
+	if reconstructed.StackFrames() != nil {
+		t.Error("errors without captured program counters have no runtime frames")
+	}
+}
+
+func TestSymbolizeOnce(t *testing.T) {
+	before := atomic.LoadInt64(&stackSymbolizeCount)
+	err := New("lazy")
+	if count := atomic.LoadInt64(&stackSymbolizeCount) - before; count != 0 {
+		t.Fatalf("expected no symbolization before the stack is needed, got %d", count)
+	}
+
+	first := err.GetStack()
+	second := err.GetStack()
+	if count := atomic.LoadInt64(&stackSymbolizeCount) - before; count != 1 {
+		t.Errorf("expected the stack to be symbolized once, got %d", count)
+	}
+	if first == "" || first != second {
+		t.Errorf("expected the cached stack to be returned, got %q and %q", first, second)
+	}
+}
+
+func capturePCs() []uintptr {
+	pcs := make([]uintptr, 32)
+	return pcs[:runtime.Callers(1, pcs)]
+}
+
+func TestWithPCs(t *testing.T) {
+	pcs := capturePCs()
+
+	err := NewWithPCs("instrumented", pcs).(*DropboxBaseError)
+	frames := err.GetStackFrames()
+	if len(frames) < 2 ||
+		!strings.HasSuffix(frames[0].Function, "errors.capturePCs") ||
+		!strings.HasSuffix(frames[1].Function, "errors.TestWithPCs") {
+		t.Fatalf("expected the frames of the supplied program counters, got %v", frames)
+	}
+	if !strings.Contains(err.GetStack(), "errors.capturePCs") {
+		t.Errorf("expected the supplied frames in the stack:\n%s", err.GetStack())
+	}
+
+	inner := fmt.Errorf("timeout")
+	wrapped := WrapWithPCs(inner, "instrumented", pcs).(*DropboxBaseError)
+	if wrapped.GetInner() != inner {
+		t.Error("expected the wrapped error as inner error")
+	}
+	if f := wrapped.GetStackFrames(); !reflect.DeepEqual(f, frames) {
+		t.Errorf("unexpected frames %v, expected %v", f, frames)
+	}
+
+	// The error shouldn't be affected by later changes to the caller's slice.
+	copied := NewWithPCs("instrumented", pcs).(*DropboxBaseError)
+	pcs[0] = 0
+	if f := copied.GetStackFrames(); !reflect.DeepEqual(f, frames) {
+		t.Errorf("unexpected frames after modifying the program counters %v", f)
+	}
+}
+
+func TestProgramCounters(t *testing.T) {
+	err := New("instrumented").(*DropboxBaseError)
+	pcs := err.ProgramCounters()
+	frame, _ := runtime.CallersFrames(pcs).Next()
+	if !strings.HasSuffix(frame.Function, "errors.TestProgramCounters") {
+		t.Fatalf("expected the program counters to resolve to the test function, got %q", frame.Function)
+	}
+
+	// The error shouldn't be affected by changes to the returned slice.
+	pcs[0] = 0
+	if frame, _ := runtime.CallersFrames(err.ProgramCounters()).Next(); !strings.HasSuffix(frame.Function, "errors.TestProgramCounters") {
+		t.Errorf("unexpected frame after modifying the program counters %q", frame.Function)
+	}
+
+	reconstructed := &DropboxBaseError{Msg: "query failed", Stack: testStack}
+	if pcs := reconstructed.ProgramCounters(); pcs == nil || len(pcs) != 0 {
+		t.Errorf("expected an empty slice without captured program counters, got %v", pcs)
+	}
+}
+
+func TestSetSymbolizer(t *testing.T) {
+	defer SetSymbolizer(DefaultSymbolizer)
+	SetSymbolizer(func(pcs []uintptr) string {
+		return fmt.Sprintf("<%d frames>", len(runtimeFrames(pcs)))
+	})
+
+	err := New("custom").(*DropboxBaseError)
+	frames := err.StackFrames()
+	if stack := err.GetStack(); stack != fmt.Sprintf("<%d frames>", len(frames)) {
+		t.Errorf("unexpected custom stack %q", stack)
+	}
+	if !strings.Contains(err.Error(), err.GetStack()) {
+		t.Errorf("expected the custom stack in the error:\n%s", err.Error())
+	}
+	if f := err.GetStackFrames(); len(f) == 0 || !strings.HasSuffix(f[0].Function, "errors.TestSetSymbolizer") {
+		t.Errorf("structured frames should not be affected by the symbolizer, got %v", f)
+	}
+
+	SetSymbolizer(DefaultSymbolizer)
+	if stack := New("default").GetStack(); !strings.Contains(stack, "errors.TestSetSymbolizer(...)\n\t") {
+		t.Errorf("unexpected default stack %q", stack)
+	}
+}
+
+func TestHasOriginalStack(t *testing.T) {
+	retained := Wrap(New("connection refused"), "query failed")
+	if !HasOriginalStack(retained) {
+		t.Error("expected the original stack to be retained")
+	}
+
+	stringified := Wrap(fmt.Errorf("%s", GetMessage(retained)), "handler failed")
+	if HasOriginalStack(stringified) {
+		t.Error("expected the stack to be lost for a stringified error")
+	}
+	if HasOriginalStack(Wrap(NewSentinel("closed"), "write failed")) {
+		t.Error("expected no original stack for a sentinel")
+	}
+	if HasOriginalStack(fmt.Errorf("plain")) || HasOriginalStack(nil) {
+		t.Error("expected no original stack without DropboxErrors")
+	}
+}
+
+func stackOrderOuter() *DropboxBaseError  { return stackOrderMiddle() }
+func stackOrderMiddle() *DropboxBaseError { return stackOrderInner() }
+func stackOrderInner() *DropboxBaseError {
+	return New("error site").(*DropboxBaseError)
+}
+
+func TestGetStackFramesOrder(t *testing.T) {
+	err := stackOrderOuter()
+	frames := err.GetStackFrames()
+
+	expected := []string{
+		"errors.stackOrderInner",
+		"errors.stackOrderMiddle",
+		"errors.stackOrderOuter",
+		"errors.TestGetStackFramesOrder",
+	}
+	if len(frames) < len(expected) {
+		t.Fatalf("expected at least %d frames, got %v", len(expected), frames)
+	}
+	for i, function := range expected {
+		if !strings.HasSuffix(frames[i].Function, function) {
+			t.Errorf("expected %s at position %d, got %s", function, i, frames[i].Function)
+		}
+	}
+	if !reflect.DeepEqual(err.GetStackFrames(), frames) {
+		t.Error("expected the same frames on every call")
+	}
+	if !reflect.DeepEqual(parseStack(err.GetStack()), frames) {
+		t.Error("expected the formatted stack in the same order")
+	}
+}
+
+func TestStackHelpersWithCustomSymbolizer(t *testing.T) {
+	defer SetSymbolizer(DefaultSymbolizer)
+	SetSymbolizer(func(pcs []uintptr) string { return "custom" })
+
+	err := Wrap(New("query failed"), "handler failed")
+	if err.GetStack() != "custom" {
+		t.Fatalf("expected the custom stack, got %q", err.GetStack())
+	}
+
+	if s := CompactStack(err); !strings.HasPrefix(s, "stack_test.go:") {
+		t.Errorf("unexpected compact stack %q", s)
+	}
+	if frames := AppStack(err, []string{""}); len(frames) == 0 ||
+		!strings.HasSuffix(frames[0].Function, "errors.TestStackHelpersWithCustomSymbolizer") {
+		t.Errorf("unexpected app frames %v", frames)
+	}
+	if frame, ok := BlameFrame(err, []string{"runtime."}); !ok ||
+		!strings.HasSuffix(frame.Function, "errors.TestStackHelpersWithCustomSymbolizer") {
+		t.Errorf("unexpected blame frame %v", frame)
+	}
+	if f, _ := AnalyticsRow(err)["top_function"].(string); !strings.HasSuffix(f, "errors.TestStackHelpersWithCustomSymbolizer") {
+		t.Errorf("unexpected top_function %q", f)
+	}
+	if s := LogfmtString(err); !strings.Contains(s, "errors.TestStackHelpersWithCustomSymbolizer") {
+		t.Errorf("expected the location in %q", s)
+	}
+	if Hash(err) == Hash(Wrap(New("query failed"), "handler failed")) {
+		t.Error("expected errors created at different lines to hash differently")
+	}
+}
+
+func TestShortFunctionName(t *testing.T) {
+	for function, expected := range map[string]string{
+		"github.com/saleswise/app.(*Server).Handle": "(*Server).Handle",
+		"gopkg.in/check%2ev1.(*C).Run":              "(*C).Run",
+		"main.main":                                 "main",
+		"net/http.HandlerFunc.ServeHTTP":            "HandlerFunc.ServeHTTP",
+		"main.main.func1":                           "main.func1",
+	} {
+		if short := shortFunctionName(function); short != expected {
+			t.Errorf("unexpected short name %q for %q, expected %q", short, function, expected)
+		}
+	}
+}
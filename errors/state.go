@@ -0,0 +1,376 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// When set, state maps are flattened (see FlattenNestedState) before being
+// rendered by DefaultError and GetAnnotatedStates.
+var flattenStateRendering = false
+
+// SetFlattenStateRendering controls whether state is rendered as flat dotted
+// keys (e.g. "user.id") instead of nested maps.  This should be called during
+// initialization.
+func SetFlattenStateRendering(flatten bool) {
+	flattenStateRendering = flatten
+}
+
+// FlattenNestedState returns a copy of state where nested maps are flattened
+// into dotted keys, e.g. {"user": {"id": 1}} becomes {"user.id": 1}.  Slices
+// and arrays are flattened by index, e.g. {"items": [1]} becomes
+// {"items.0": 1}.  Empty maps and slices are kept as-is, and maps or slices
+// containing themselves are rendered as "<cycle>" where they repeat.
+func FlattenNestedState(state map[string]interface{}) map[string]interface{} {
+	if state == nil {
+		return nil
+	}
+
+	out := make(map[string]interface{}, len(state))
+	onPath := map[visitKey]bool{keyOf(reflect.ValueOf(state)): true}
+	for key, value := range state {
+		flattenValue(out, key, value, onPath)
+	}
+	return out
+}
+
+// Identifies a map or slice, to detect cycles (and shared references) in
+// state.
+type visitKey struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+func keyOf(v reflect.Value) visitKey {
+	return visitKey{ptr: v.Pointer(), len: v.Len(), typ: v.Type()}
+}
+
+// Flattens value into out under prefix.  onPath holds the maps and slices
+// being flattened, i.e. value's ancestors.
+func flattenValue(out map[string]interface{}, prefix string, value interface{}, onPath map[visitKey]bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		if v.Len() == 0 {
+			break
+		}
+		key := keyOf(v)
+		if onPath[key] {
+			out[prefix] = "<cycle>"
+			return
+		}
+		onPath[key] = true
+		defer delete(onPath, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Len() == 0 {
+			break
+		}
+		for _, key := range v.MapKeys() {
+			flattenValue(
+				out,
+				prefix+"."+fmt.Sprint(key.Interface()),
+				v.MapIndex(key).Interface(),
+				onPath)
+		}
+		return
+	case reflect.Slice, reflect.Array:
+		// Byte slices are more useful as a single value.
+		if v.Len() == 0 || v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			flattenValue(out, fmt.Sprintf("%s.%d", prefix, i), v.Index(i).Interface(), onPath)
+		}
+		return
+	}
+	out[prefix] = value
+}
+
+// Returns the state as it should be rendered.
+func renderedState(state map[string]interface{}) map[string]interface{} {
+	if flattenStateRendering {
+		return FlattenNestedState(state)
+	}
+	return state
+}
+
+// DeepClone returns a copy of e whose state is deep-copied, so that nested
+// maps and slices in the clone's state can be mutated without affecting e.
+// The inner error is shared with e, not cloned.
+//
+// Maps and slices referenced several times (including by themselves) are
+// copied once, so the clone's state has the same shape as e's.
+//
+// NOTE: Only maps, slices and arrays are copied recursively.  Other values,
+// most notably pointers, channels and functions, are kept by reference.
+func (e *DropboxBaseError) DeepClone() DropboxError {
+	if e == nil {
+		return nil
+	}
+
+	var state map[string]interface{}
+	e.stateMutex.RLock()
+	if e.State != nil {
+		copied := make(map[visitKey]reflect.Value)
+		state = deepCopy(reflect.ValueOf(e.State), copied).Interface().(map[string]interface{})
+	}
+	e.stateMutex.RUnlock()
+	c := e.copy()
+	c.State = state
+	return c
+}
+
+// Deep-copies v.  copied holds the copies of the maps and slices seen so
+// far, which are reused for further references to them.
+func deepCopy(v reflect.Value, copied map[visitKey]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), copied))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		id := keyOf(v)
+		if c, ok := copied[id]; ok {
+			return c
+		}
+		c := reflect.MakeMap(v.Type())
+		copied[id] = c
+		for _, key := range v.MapKeys() {
+			c.SetMapIndex(key, deepCopy(v.MapIndex(key), copied))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		id := keyOf(v)
+		if c, ok := copied[id]; ok {
+			return c
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		copied[id] = c
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// StripState returns a copy of err's chain with the state of every error
+// cleared, but messages and stacks intact.  This is useful before persisting
+// or sharing errors whose state may hold large or sensitive data.  err itself
+// is not modified.  Plain errors wrapping DropboxErrors (e.g. using
+// fmt.Errorf's %w verb) are replaced by their own message (see Chain), and a
+// plain err is wrapped without adding a message.
+func StripState(err error) DropboxError {
+	if err == nil {
+		return nil
+	}
+	stripped := stripState(err)
+	if dbe, ok := stripped.(DropboxError); ok {
+		return dbe
+	}
+	return &DropboxBaseError{inner: stripped}
+}
+
+// Returns a copy of err's chain with the state of every DropboxError
+// cleared, including those held by MultiErrors and plain errors.  Plain
+// errors whose chain holds no DropboxError have no state, and are returned
+// as-is.
+func stripState(err error) error {
+	switch e := err.(type) {
+	case *MultiError:
+		m := e.copy()
+		for i, sub := range m.errs {
+			m.errs[i] = stripState(sub)
+		}
+		m.SetState(nil)
+		return m
+	case DropboxError:
+		var inner error
+		if e.GetInner() != nil {
+			inner = stripState(e.GetInner())
+		}
+		link := relink(e, inner)
+		link.SetState(nil)
+		return link
+	default:
+		inners := unwrap(err)
+		stripped := make([]error, 0, len(inners))
+		changed := false
+		for _, inner := range inners {
+			s := stripState(inner)
+			stripped = append(stripped, s)
+			changed = changed || !isComparable(s) || s != inner
+		}
+		if !changed {
+			return err
+		}
+		if len(stripped) == 1 {
+			return relink(err, stripped[0])
+		}
+		return relink(err, stderrors.Join(stripped...))
+	}
+}
+
+// GetAnnotatedStatesLimited returns at most max of the annotated states of
+// err's chain (see DropboxError.GetAnnotatedStates), outermost first, for
+// any error, including plain errors wrapping DropboxErrors.  If any were left
+// out, a final summary entry records how many under "_omitted".  This bounds
+// the size of reports for very deep chains.
+func GetAnnotatedStatesLimited(err error, max int) []map[string]interface{} {
+	if err == nil {
+		return nil
+	}
+
+	states := annotatedStates(err)
+
+	if max < 0 {
+		max = 0
+	}
+	if len(states) <= max {
+		return states
+	}
+	return append(
+		states[:max:max],
+		map[string]interface{}{"_omitted": len(states) - max})
+}
+
+// AggregateState returns a combined view of the states of an aggregate
+// error, i.e. the first error in err's chain which wraps several errors
+// through an Unwrap() []error method (e.g. one created by the standard
+// library's errors.Join).  The merged state of each sub-error's chain is
+// stored under the sub-error's index ("0", "1", ...), and state keys shared
+// by all sub-errors with equal values are also stored at the top level.
+// Returns nil if err's chain has no aggregate error.
+func AggregateState(err error) map[string]interface{} {
+	for _, e := range walk(err) {
+		aggregate, ok := e.(interface{ Unwrap() []error })
+		if !ok {
+			continue
+		}
+
+		out := make(map[string]interface{})
+		var common map[string]interface{}
+		for i, sub := range aggregate.Unwrap() {
+			state := mergedState(sub)
+			out[strconv.Itoa(i)] = state
+			if common == nil {
+				common = make(map[string]interface{}, len(state))
+				for key, value := range state {
+					common[key] = value
+				}
+				continue
+			}
+			for key, value := range common {
+				if other, ok := state[key]; !ok || !reflect.DeepEqual(value, other) {
+					delete(common, key)
+				}
+			}
+		}
+		for key, value := range common {
+			out[key] = value
+		}
+		return out
+	}
+	return nil
+}
+
+// StateOrigin returns the top frame of the stack of the outermost error in
+// err's chain whose own state holds key, i.e. where the key was attached.
+// The returned bool reports whether any error holds key; the frame is empty
+// if that error has no stack (e.g. a sentinel).
+func StateOrigin(err error, key string) (StackFrame, bool) {
+	for _, e := range walk(err) {
+		dbe, ok := e.(DropboxError)
+		if !ok {
+			continue
+		}
+		if _, ok := dbe.GetState()[key]; !ok {
+			continue
+		}
+
+		frames := errorFrames(dbe)
+		if len(frames) == 0 {
+			return StackFrame{}, true
+		}
+		return frames[0], true
+	}
+	return StackFrame{}, false
+}
+
+// FirstStateOfType returns the first state value of err's chain which is a
+// T, regardless of its key.  Levels are scanned outermost first, and keys in
+// sorted order within a level.  Since JSON decodes all numbers as float64,
+// integral float64 values are also accepted (and converted) for integer and
+// float32 types T.
+func FirstStateOfType[T any](err error) (T, bool) {
+	target := reflect.TypeOf((*T)(nil)).Elem()
+	for _, e := range walk(err) {
+		dbe, ok := e.(DropboxError)
+		if !ok {
+			continue
+		}
+		state := dbe.GetState()
+		keys := make([]string, 0, len(state))
+		for key := range state {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if v, ok := state[key].(T); ok {
+				return v, true
+			}
+			if f, ok := state[key].(float64); ok {
+				if v, ok := convertFloat(f, target); ok {
+					return v.Interface().(T), true
+				}
+			}
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// Converts f to the numeric type target, if it is representable without
+// loss of integrality.
+func convertFloat(f float64, target reflect.Type) (reflect.Value, bool) {
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 ||
+			reflect.Zero(target).OverflowInt(int64(f)) {
+			return reflect.Value{}, false
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 ||
+			reflect.Zero(target).OverflowUint(uint64(f)) {
+			return reflect.Value{}, false
+		}
+	case reflect.Float32:
+	default:
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(f).Convert(target), true
+}
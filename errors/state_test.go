@@ -0,0 +1,344 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlattenNestedState(t *testing.T) {
+	state := map[string]interface{}{
+		"request": "abc",
+		"user": map[string]interface{}{
+			"id": 42,
+			"org": map[string]interface{}{
+				"name": "saleswise",
+			},
+		},
+		"items": []interface{}{"a", map[string]interface{}{"b": 2}},
+		"empty": map[string]interface{}{},
+	}
+
+	expected := map[string]interface{}{
+		"request":       "abc",
+		"user.id":       42,
+		"user.org.name": "saleswise",
+		"items.0":       "a",
+		"items.1.b":     2,
+		"empty":         map[string]interface{}{},
+	}
+	if flat := FlattenNestedState(state); !reflect.DeepEqual(flat, expected) {
+		t.Errorf("unexpected flattened state:\n%v\nexpected:\n%v", flat, expected)
+	}
+
+	if FlattenNestedState(nil) != nil {
+		t.Error("flattening nil state should return nil")
+	}
+
+	cyclic := map[string]interface{}{"id": 1}
+	cyclic["self"] = cyclic
+	shared := []interface{}{"x"}
+	expected = map[string]interface{}{
+		"user.id":   1,
+		"user.self": "<cycle>",
+		"a.0":       "x",
+		"b.0":       "x",
+	}
+	state = map[string]interface{}{"user": cyclic, "a": shared, "b": shared}
+	if flat := FlattenNestedState(state); !reflect.DeepEqual(flat, expected) {
+		t.Errorf("unexpected flattened cyclic state:\n%v\nexpected:\n%v", flat, expected)
+	}
+}
+
+func TestFlattenStateRendering(t *testing.T) {
+	defer SetFlattenStateRendering(false)
+
+	err := New("nested").SetState(map[string]interface{}{
+		"user": map[string]interface{}{"id": 42},
+	})
+
+	SetFlattenStateRendering(true)
+	if s := err.Error(); strings.Index(s, `"user.id":42`) == -1 {
+		t.Errorf("couldn't find flattened state in:\n%s", s)
+	}
+	cyclic := map[string]interface{}{"id": 42}
+	cyclic["self"] = cyclic
+	if s := New("cyclic").WithField("user", cyclic).Error(); strings.Index(s, `"user.id":42`) == -1 {
+		t.Errorf("couldn't find flattened cyclic state in:\n%s", s)
+	}
+	if states := err.GetAnnotatedStates(); states[0]["user.id"] != 42 {
+		t.Errorf("expected flattened annotated state, got %v", states[0])
+	}
+}
+
+func TestDeepClone(t *testing.T) {
+	ch := make(chan int)
+	original := New("original").SetState(map[string]interface{}{
+		"user":  map[string]interface{}{"id": 42},
+		"items": []interface{}{"a", "b"},
+		"ch":    ch,
+	})
+
+	clone := original.(*DropboxBaseError).DeepClone()
+	clone.GetState()["user"].(map[string]interface{})["id"] = 7
+	clone.GetState()["items"].([]interface{})[0] = "z"
+	clone.GetState()["new"] = true
+
+	state := original.GetState()
+	if state["user"].(map[string]interface{})["id"] != 42 {
+		t.Error("mutating the clone's nested map changed the original")
+	}
+	if state["items"].([]interface{})[0] != "a" {
+		t.Error("mutating the clone's nested slice changed the original")
+	}
+	if _, ok := state["new"]; ok {
+		t.Error("adding to the clone's state changed the original")
+	}
+	if clone.GetState()["ch"] != ch {
+		t.Error("channels should be kept by reference")
+	}
+	if clone.GetMessage() != original.GetMessage() ||
+		clone.GetStack() != original.GetStack() {
+		t.Error("clone should retain message and stack")
+	}
+
+	cyclic := map[string]interface{}{"id": 42}
+	cyclic["self"] = cyclic
+	shared := []interface{}{"a"}
+	original = New("cyclic").SetState(map[string]interface{}{
+		"user": cyclic,
+		"a":    shared,
+		"b":    shared,
+	})
+	state = original.(*DropboxBaseError).DeepClone().GetState()
+	user := state["user"].(map[string]interface{})
+	user["id"] = 7
+	if user["self"].(map[string]interface{})["id"] != 7 || cyclic["id"] != 42 {
+		t.Error("the clone should keep its own cyclic reference")
+	}
+	state["a"].([]interface{})[0] = "z"
+	if state["b"].([]interface{})[0] != "z" || shared[0] != "a" {
+		t.Error("the clone should keep its own shared reference")
+	}
+}
+
+func TestStripState(t *testing.T) {
+	leaf := fmt.Errorf("connection refused")
+	inner := Wrap(leaf, "query failed").SetState(map[string]interface{}{"password": "hunter2"})
+	outer := Wrap(inner, "handler failed").SetState(map[string]interface{}{"user_id": 42})
+
+	stripped := StripState(outer)
+	links := DropboxChain(stripped)
+	if len(links) != 2 {
+		t.Fatalf("unexpected chain length %d", len(links))
+	}
+	for i, original := range []DropboxError{outer, inner} {
+		if links[i].GetState() != nil {
+			t.Errorf("state not stripped: %v", links[i].GetState())
+		}
+		if links[i].GetMessage() != original.GetMessage() ||
+			links[i].GetStack() != original.GetStack() {
+			t.Errorf("message and stack not preserved for %q", original.GetMessage())
+		}
+	}
+	if links[1].GetInner() != leaf {
+		t.Error("plain leaf error should be preserved")
+	}
+
+	if outer.GetState()["user_id"] != 42 || inner.GetState()["password"] != "hunter2" {
+		t.Error("original errors should not be modified")
+	}
+	if StripState(nil) != nil {
+		t.Error("expected nil for nil error")
+	}
+
+	first := New("first").WithField("token", "abc")
+	second := fmt.Errorf("second")
+	batch := Wrap(Append(nil, first, second).WithField("batch", 1), "outer")
+	flat := Flatten(StripState(batch))
+	if len(flat) != 4 {
+		t.Fatalf("expected the errors of the MultiError to be kept, got %v", flat)
+	}
+	for _, link := range flat[:3] {
+		if state := link.(DropboxError).GetState(); state != nil {
+			t.Errorf("state not stripped: %v", state)
+		}
+	}
+	if GetMessage(flat[2]) != "first" || flat[3] != second {
+		t.Errorf("unexpected errors of the stripped MultiError: %v", flat[2:])
+	}
+	if first.GetState()["token"] != "abc" {
+		t.Error("errors of the original MultiError should not be modified")
+	}
+
+	// DropboxErrors wrapped by plain errors are stripped too.
+	plain := fmt.Errorf("handler: %w", inner)
+	flat = Flatten(StripState(plain))
+	if len(flat) != 3 || flat[0].(DropboxError).GetMessage() != "handler" || flat[2] != leaf {
+		t.Fatalf("expected the chain under a plain error to be kept, got %v", flat)
+	}
+	if state := flat[1].(DropboxError).GetState(); state != nil {
+		t.Errorf("state not stripped under a plain error: %v", state)
+	}
+	if flat[1].(DropboxError).GetStack() != inner.GetStack() {
+		t.Error("stack not preserved under a plain error")
+	}
+	if stripped := StripState(leaf); !stderrors.Is(stripped, leaf) {
+		t.Errorf("expected a plain error to be wrapped, got %v", stripped)
+	}
+}
+
+func TestGetAnnotatedStatesLimited(t *testing.T) {
+	err := Wrap(Wrap(Wrap(fmt.Errorf("leaf"), "third"), "second"), "first")
+
+	states := GetAnnotatedStatesLimited(err, 10)
+	if len(states) != 4 {
+		t.Errorf("expected all 4 states, got %v", states)
+	}
+
+	states = GetAnnotatedStatesLimited(err, 2)
+	if len(states) != 3 {
+		t.Fatalf("expected 2 states and a summary, got %v", states)
+	}
+	if states[0]["_message"] != "first" || states[1]["_message"] != "second" {
+		t.Errorf("expected the outermost states first, got %v", states)
+	}
+	if states[2]["_omitted"] != 2 {
+		t.Errorf("expected 2 omitted states, got %v", states[2])
+	}
+
+	if GetAnnotatedStatesLimited(nil, 2) != nil {
+		t.Error("expected no states for nil error")
+	}
+
+	plain := fmt.Errorf("handler: %w", Wrap(New("query failed").WithField("table", "users"), "fetch failed"))
+	states = GetAnnotatedStatesLimited(plain, 10)
+	if len(states) != 3 || states[0]["_message"] != "handler" || states[2]["table"] != "users" {
+		t.Errorf("expected the chain under a plain error to be traversed, got %v", states)
+	}
+}
+
+func TestAggregateState(t *testing.T) {
+	first := New("first").SetState(map[string]interface{}{
+		"request_id": "abc",
+		"shard":      1,
+	})
+	second := Wrap(
+		New("second").SetState(map[string]interface{}{"request_id": "abc", "shard": 2}),
+		"wrapped").SetState(map[string]interface{}{"table": "users"})
+	err := Wrap(stderrors.Join(first, second), "batch failed")
+
+	expected := map[string]interface{}{
+		"0":          map[string]interface{}{"request_id": "abc", "shard": 1},
+		"1":          map[string]interface{}{"request_id": "abc", "shard": 2, "table": "users"},
+		"request_id": "abc",
+	}
+	if state := AggregateState(err); !reflect.DeepEqual(state, expected) {
+		t.Errorf("unexpected aggregate state:\n%v\nexpected:\n%v", state, expected)
+	}
+
+	if state := AggregateState(first); state != nil {
+		t.Errorf("expected no aggregate state for a plain chain, got %v", state)
+	}
+}
+
+// Meant to be run with -race.
+func TestConcurrentStateAccess(t *testing.T) {
+	shared := Wrap(New("inner").WithField("k", 0), "shared").WithField("k", 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				shared.WithField("k", j)
+				shared.WithFields(map[string]interface{}{"writer": i})
+				shared.SetState(map[string]interface{}{"k": -j})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				shared.GetState()["mine"] = j
+				shared.GetAnnotatedStates()
+				_ = shared.Error()
+				shared.(*DropboxBaseError).WithState(map[string]interface{}{"x": j})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := shared.GetState()["mine"]; ok {
+		t.Error("modifying the result of GetState should not change the error")
+	}
+}
+
+func addRequestID(err error) DropboxError {
+	return Wrap(err, "request failed").WithField("request_id", "r-42")
+}
+
+func TestStateOrigin(t *testing.T) {
+	inner := New("query failed").WithField("table", "users")
+	err := Wrap(addRequestID(inner), "handler failed").WithField("handler", "profile")
+
+	frame, ok := StateOrigin(err, "request_id")
+	if !ok || !strings.HasSuffix(frame.Function, "errors.addRequestID") ||
+		!strings.HasSuffix(frame.File, "state_test.go") {
+		t.Errorf("expected the frame of addRequestID, got %v", frame)
+	}
+	if frame, ok := StateOrigin(err, "table"); !ok ||
+		!strings.HasSuffix(frame.Function, "errors.TestStateOrigin") {
+		t.Errorf("expected the frame of the test, got %v", frame)
+	}
+
+	// The outermost error holding the key wins.
+	shadowed := Wrap(err, "retry failed").WithField("table", "accounts")
+	if frame, ok := StateOrigin(shadowed, "table"); !ok || frame.Line == inner.(*DropboxBaseError).Frames()[0].Line {
+		t.Errorf("expected the outermost origin, got %v", frame)
+	}
+
+	if _, ok := StateOrigin(err, "missing"); ok {
+		t.Error("expected no origin for a missing key")
+	}
+	if frame, ok := StateOrigin(NewSentinel("closed").WithField("fd", 3), "fd"); !ok || frame != (StackFrame{}) {
+		t.Errorf("expected an empty frame for an error without stack, got %v", frame)
+	}
+}
+
+func TestFirstStateOfType(t *testing.T) {
+	inner := New("decoded").WithFields(map[string]interface{}{
+		"ratio":   0.5,
+		"user_id": float64(42),
+	})
+	err := Wrap(inner, "handler failed").WithFields(map[string]interface{}{
+		"flag": true,
+		"name": "profile",
+	})
+
+	if s, ok := FirstStateOfType[string](err); !ok || s != "profile" {
+		t.Errorf("unexpected string %q", s)
+	}
+	if n, ok := FirstStateOfType[int](err); !ok || n != 42 {
+		t.Errorf("expected the integral float64 as an int, got %v", n)
+	}
+	if n, ok := FirstStateOfType[uint8](Wrap(inner, "x").WithField("big", float64(300))); !ok || n != 42 {
+		t.Errorf("expected values overflowing the type to be skipped, got %v", n)
+	}
+	if f, ok := FirstStateOfType[float64](err); !ok || f != 0.5 {
+		t.Errorf("unexpected float %v", f)
+	}
+	if _, ok := FirstStateOfType[time.Duration](err); !ok {
+		t.Error("expected an integral float64 to convert to an integer type")
+	}
+	if _, ok := FirstStateOfType[[]string](err); ok {
+		t.Error("expected no value of an absent type")
+	}
+	if _, ok := FirstStateOfType[int](fmt.Errorf("plain")); ok {
+		t.Error("expected no value without state")
+	}
+}
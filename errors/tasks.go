@@ -0,0 +1,44 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"sort"
+)
+
+// WrapTasks aggregates the errors of a batch of tasks (e.g. run by a worker
+// pool), keyed by task ID, into a single error which preserves which tasks
+// failed.  Each non-nil error is wrapped with its task ID in its message
+// ("task <id> failed") and in its state (under "_task_id"), and the wrapped
+// errors are aggregated, in task ID order, with the standard library's
+// errors.Join (see also AggregateState), up to the limit set by
+// SetMaxAggregatedErrors.  Returns nil if no task failed.
+func WrapTasks(results map[string]error) DropboxError {
+	ids := make([]string, 0, len(results))
+	for id, err := range results {
+		if err != nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Strings(ids)
+
+	// The stack is shared by all the errors created here.
+	pcs := callers()
+	failed := make([]error, 0, len(ids))
+	for _, id := range ids {
+		failed = append(failed, enrich(&DropboxBaseError{
+			Msg:   sanitizeMessage(fmt.Sprintf("task %s failed", id)),
+			State: map[string]interface{}{"_task_id": id},
+			pcs:   pcs,
+			inner: results[id],
+		}))
+	}
+	return enrich(&DropboxBaseError{
+		Msg:   sanitizeMessage(fmt.Sprintf("%d of %d tasks failed", len(ids), len(results))),
+		pcs:   pcs,
+		inner: stderrors.Join(capAggregated(failed)...),
+	})
+}
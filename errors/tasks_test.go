@@ -0,0 +1,51 @@
+package errors
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWrapTasks(t *testing.T) {
+	timeout := fmt.Errorf("timeout")
+	err := WrapTasks(map[string]error{
+		"b": New("disk full"),
+		"a": timeout,
+		"c": nil,
+	})
+	if err == nil {
+		t.Fatal("expected an aggregate error")
+	}
+	if msg := err.GetMessage(); msg != "2 of 3 tasks failed" {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if strings.Index(err.GetStack(), "TestWrapTasks") == -1 {
+		t.Errorf("stack trace must have test code in it:\n%s", err.GetStack())
+	}
+
+	var ids []string
+	for _, e := range DropboxChain(err) {
+		if id, ok := e.GetState()["_task_id"]; ok {
+			ids = append(ids, id.(string))
+			if !strings.Contains(e.GetMessage(), id.(string)) {
+				t.Errorf("expected the task ID in the message %q", e.GetMessage())
+			}
+		}
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("unexpected failed task IDs %v", ids)
+	}
+	if !ContainsError(err, timeout) {
+		t.Error("expected the task errors to be part of the chain")
+	}
+
+	state := AggregateState(err)
+	if sub, ok := state["1"].(map[string]interface{}); !ok || sub["_task_id"] != "b" {
+		t.Errorf("unexpected aggregate state %v", state)
+	}
+
+	if WrapTasks(map[string]error{"a": nil}) != nil || WrapTasks(nil) != nil {
+		t.Error("expected nil when no task failed")
+	}
+}
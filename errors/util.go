@@ -1,6 +1,9 @@
 package errors
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 // TODO(jasonparekh) TECHDEBT import cycle on miscutil
 
@@ -20,3 +23,13 @@ func IndexNth(s, sep string, n int) int {
 
 	return lastIdx
 }
+
+// Returns the first of the given frames (innermost first), formatted as
+// "function file:line".  Volatile details (the goroutine header, argument
+// values and pc offsets) are excluded.  Returns "" if there are no frames.
+func topFrame(frames []StackFrame) string {
+	if len(frames) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %s:%d", frames[0].Function, frames[0].File, frames[0].Line)
+}